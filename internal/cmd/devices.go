@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"github.com/pmeier/telescope/internal/devices"
+	"github.com/spf13/cobra"
+)
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List the devices reported by the sources",
+	Run:   runFunc(devices.Run),
+}
+
+func init() {
+	rootCmd.AddCommand(devicesCmd)
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"github.com/pmeier/telescope/internal/migrate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateTo    uint
+	migrateSteps uint
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage the database schema",
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply pending migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		runFunc(migrate.Up(migrateTo))(cmd, args)
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert applied migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		runFunc(migrate.Down(migrateSteps))(cmd, args)
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List the migrations and whether they are applied",
+	Run:   runFunc(migrate.Status),
+}
+
+func init() {
+	migrateUpCmd.Flags().UintVar(&migrateTo, "to", 0, "version to migrate up to, defaults to the latest")
+	migrateDownCmd.Flags().UintVar(&migrateSteps, "steps", 1, "number of migrations to revert")
+
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
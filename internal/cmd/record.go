@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"github.com/pmeier/telescope/internal/observe"
+	"github.com/spf13/cobra"
+)
+
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Record raw measurements for later replay",
+	Run:   runFunc(observe.Record),
+}
+
+func init() {
+	recordCmd.Flags().StringP("output", "o", "", "path of the recording file")
+	bindConfigFlag(recordCmd, "output", "record.path")
+
+	rootCmd.AddCommand(recordCmd)
+}
@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"github.com/pmeier/telescope/internal/retention"
+	"github.com/spf13/cobra"
+)
+
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Roll up and delete old data once",
+	Run:   runFunc(retention.Run),
+}
+
+func init() {
+	retentionCmd.Flags().Bool("dry-run", false, "only report what would be rolled up and deleted")
+	bindConfigFlag(retentionCmd, "dry-run", "observe.storage.retention.dryrun")
+
+	rootCmd.AddCommand(retentionCmd)
+}
@@ -1,13 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/pmeier/telescope/internal/config"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+const configKeyAnnotation = "telescope_config_key"
+
 var rootCmd = &cobra.Command{
 	Use:   "telescope",
 	Short: "Observe Sungrow plant",
@@ -20,16 +26,19 @@ func Execute() {
 	}
 }
 
-func runFunc(fn func(config.Config) error) func(*cobra.Command, []string) {
+func runFunc(fn func(context.Context, config.Config) error) func(*cobra.Command, []string) {
 	return func(cmd *cobra.Command, args []string) {
 		var code int
 		if err := func() error {
-			c, err := config.Load()
+			c, err := config.Load(configOverrides(cmd))
 			if err != nil {
 				return err
 			}
 
-			return fn(*c)
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return fn(ctx, *c)
 		}(); err != nil {
 			fmt.Printf("Error: %s\n", err.Error())
 			code = 1
@@ -38,3 +47,18 @@ func runFunc(fn func(config.Config) error) func(*cobra.Command, []string) {
 		os.Exit(code)
 	}
 }
+
+// bindConfigFlag makes the flag with the given name override the config key if it is set on the command line.
+func bindConfigFlag(cmd *cobra.Command, name string, key string) {
+	cmd.Flags().SetAnnotation(name, configKeyAnnotation, []string{key})
+}
+
+func configOverrides(cmd *cobra.Command) map[string]string {
+	overrides := map[string]string{}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if keys, ok := f.Annotations[configKeyAnnotation]; ok {
+			overrides[keys[0]] = f.Value.String()
+		}
+	})
+	return overrides
+}
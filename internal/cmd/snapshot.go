@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"github.com/pmeier/telescope/internal/observe"
+	"github.com/spf13/cobra"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Print a single summary and exit",
+	Run:   runFunc(observe.Snapshot),
+}
+
+func init() {
+	snapshotCmd.Flags().StringP("format", "f", "", "output format, one of table, json, or csv")
+	bindConfigFlag(snapshotCmd, "format", "snapshot.format")
+
+	rootCmd.AddCommand(snapshotCmd)
+}
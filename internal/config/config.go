@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"reflect"
@@ -78,25 +79,150 @@ type LoggingConfig struct {
 	Format LoggingFormat
 }
 
+type SourceKind uint8
+
+const (
+	RedgiantSourceKind SourceKind = iota
+	SimulateSourceKind
+	ReplaySourceKind
+)
+
+func (k SourceKind) String() string {
+	switch k {
+	case RedgiantSourceKind:
+		return "redgiant"
+	case SimulateSourceKind:
+		return "simulate"
+	case ReplaySourceKind:
+		return "replay"
+	default:
+		return strconv.Itoa(int(k))
+	}
+}
+
+func ParseSourceKind(kindStr string) (SourceKind, error) {
+	for _, kind := range []SourceKind{
+		RedgiantSourceKind,
+		SimulateSourceKind,
+		ReplaySourceKind,
+	} {
+		if strings.EqualFold(kindStr, kind.String()) {
+			return kind, nil
+		}
+	}
+	return RedgiantSourceKind, errors.New("unknown source kind")
+}
+
+type SimulateConfig struct {
+	PeakPVPower         float64
+	BaseLoadPower       float64
+	LoadNoise           float64
+	BatteryCapacity     float64
+	MaxBatteryPower     float64
+	InitialBatteryLevel float64 `validate:"gte=0,lte=1"`
+	MinBatteryLevel     float64 `validate:"gte=0,lte=1"`
+	Sunrise             time.Duration
+	Sunset              time.Duration `validate:"gtfield=Sunrise"`
+}
+
+type ReplayConfig struct {
+	Path  string
+	Speed float64 `validate:"gte=0"`
+}
+
+// DeviceConfig pins the device of a source by its ID or serial number. If neither is set, the summary device is
+// detected by its type. Serial numbers are only reported by the simulated source and recordings of it.
+type DeviceConfig struct {
+	ID     int    `validate:"gte=0"`
+	Serial string `validate:"excluded_unless=ID 0"`
+}
+
+type SourceConfig struct {
+	// Label identifies the device in storage, metrics, MQTT, and the UI. It is required if multiple sources are
+	// configured.
+	Label    string
+	Kind     SourceKind
+	Device   DeviceConfig
+	Redgiant RedgiantConfig
+	Simulate SimulateConfig
+	Replay   ReplayConfig
+}
+
 type RedgiantConfig struct {
 	Host string
 	Port uint
 }
 
+type DatabaseDriver uint8
+
+const (
+	PostgresDatabaseDriver DatabaseDriver = iota
+	SQLiteDatabaseDriver
+)
+
+func (d DatabaseDriver) String() string {
+	switch d {
+	case PostgresDatabaseDriver:
+		return "postgres"
+	case SQLiteDatabaseDriver:
+		return "sqlite"
+	default:
+		return strconv.Itoa(int(d))
+	}
+}
+
+func ParseDatabaseDriver(driverStr string) (DatabaseDriver, error) {
+	for _, driver := range []DatabaseDriver{
+		PostgresDatabaseDriver,
+		SQLiteDatabaseDriver,
+	} {
+		if strings.EqualFold(driverStr, driver.String()) {
+			return driver, nil
+		}
+	}
+	return PostgresDatabaseDriver, errors.New("unknown database driver")
+}
+
 type DatabaseConfig struct {
+	Driver   DatabaseDriver
 	Username string
-	Password string `validate:"required"`
+	Password string
 	Host     string
 	Port     uint
 	Name     string
+	// Path is the database file of the SQLite driver.
+	Path string
+	// AutoMigrate applies pending schema migrations on startup. Otherwise, they have to be applied with the migrate
+	// command.
+	AutoMigrate bool
+	Timescale   TimescaleConfig
+}
+
+// TimescaleConfig enables the TimescaleDB extension of the postgres driver. The data is stored in a hypertable and
+// aggregated over 1m, 1h, and 1d buckets. The aggregates hold the mean of the stored changes per bucket, which is not
+// an average over time, since values are only stored when they change.
+type TimescaleConfig struct {
+	Enabled bool
+	// CompressAfter compresses the data older than that. 0 disables compression.
+	CompressAfter time.Duration `validate:"gte=0"`
+	// Retention drops the data older than that, while its aggregates are kept. 0 keeps all data.
+	Retention time.Duration `validate:"gte=0"`
 }
 
 type ThresholdsConfig struct {
-	GridPower    float64
-	BatteryPower float64
-	PVPower      float64
-	LoadPower    float64
-	BatteryLevel float64
+	GridPower             float64
+	BatteryPower          float64
+	PVPower               float64
+	LoadPower             float64
+	BatteryLevel          float64
+	GridImportPower       float64
+	GridExportPower       float64
+	BatteryChargePower    float64
+	BatteryDischargePower float64
+	Autarky               float64
+	SelfConsumptionRate   float64
+	// Extra maps the names of quantities defined in the mapping to their thresholds. They default to 0.
+	Extra map[string]float64
 }
 
 type ThresholdWeighterConfig struct {
@@ -104,10 +230,43 @@ type ThresholdWeighterConfig struct {
 	Factor float64
 }
 
+// RawConfig configures the storage of all numeric measurements of the source keyed by their I18N code.
+type RawConfig struct {
+	Enabled bool
+}
+
+// BufferConfig configures the writes to the database. Failed writes are retried and up to Size of them are held in
+// memory. Older writes and all pending ones after Retry.MaxAttempts failures in a row are spilled to the file at
+// SpillPath and replayed once the database is available again. Without a SpillPath, they are dropped.
+type BufferConfig struct {
+	Size      uint `validate:"gte=1"`
+	SpillPath string
+	Retry     RetryConfig
+}
+
+// RollupConfig keeps the data averaged over buckets of Step for Retention. A Retention of 0 keeps the rollup forever.
+type RollupConfig struct {
+	Step      time.Duration `validate:"gte=1s"`
+	Retention time.Duration `validate:"gte=0"`
+}
+
+// RetentionConfig configures the job that deletes the data older than Data every Interval. Before it is deleted, the
+// data is downsampled into the rollups. With DryRun, the job only reports what it would do.
+type RetentionConfig struct {
+	Enabled  bool
+	Interval time.Duration  `validate:"gte=1m"`
+	Data     time.Duration  `validate:"gte=0"`
+	Rollups  []RollupConfig `validate:"dive"`
+	DryRun   bool
+}
+
 type StorageConfig struct {
 	Database          DatabaseConfig
 	Thresholds        ThresholdsConfig
 	ThresholdWeighter ThresholdWeighterConfig
+	Raw               RawConfig
+	Buffer            BufferConfig
+	Retention         RetentionConfig
 }
 
 type UIConfig struct {
@@ -115,19 +274,234 @@ type UIConfig struct {
 	Port uint
 }
 
+type RetryConfig struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64 `validate:"gte=1"`
+	MaxAttempts     uint
+}
+
+// ValidationAction is what happens to implausible samples. Dropped samples are neither integrated nor passed to the
+// handlers. Marked samples are passed to the handlers with their violations, but only the live outputs, e.g. the UI and
+// MQTT, show them. The storage never persists implausible samples to keep spikes out of the recorded data.
+type ValidationAction uint8
+
+const (
+	DropValidationAction ValidationAction = iota
+	MarkValidationAction
+)
+
+func (a ValidationAction) String() string {
+	switch a {
+	case DropValidationAction:
+		return "drop"
+	case MarkValidationAction:
+		return "mark"
+	default:
+		return strconv.Itoa(int(a))
+	}
+}
+
+func ParseValidationAction(actionStr string) (ValidationAction, error) {
+	for _, action := range []ValidationAction{
+		DropValidationAction,
+		MarkValidationAction,
+	} {
+		if strings.EqualFold(actionStr, action.String()) {
+			return action, nil
+		}
+	}
+	return DropValidationAction, errors.New("unknown validation action")
+}
+
+type EnergyBalanceConfig struct {
+	Enabled           bool
+	AbsoluteTolerance float64
+	RelativeTolerance float64
+}
+
+type ValidationConfig struct {
+	Action        ValidationAction
+	AllZero       bool
+	BatteryLevel  bool
+	EnergyBalance EnergyBalanceConfig
+}
+
+type OverflowPolicy uint8
+
+const (
+	DropOldestOverflowPolicy OverflowPolicy = iota
+	BlockOverflowPolicy
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropOldestOverflowPolicy:
+		return "drop_oldest"
+	case BlockOverflowPolicy:
+		return "block"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+func ParseOverflowPolicy(policyStr string) (OverflowPolicy, error) {
+	for _, policy := range []OverflowPolicy{
+		DropOldestOverflowPolicy,
+		BlockOverflowPolicy,
+	} {
+		if strings.EqualFold(policyStr, policy.String()) {
+			return policy, nil
+		}
+	}
+	return DropOldestOverflowPolicy, errors.New("unknown overflow policy")
+}
+
+type ErrorPolicy uint8
+
+const (
+	LogErrorPolicy ErrorPolicy = iota
+	DisableErrorPolicy
+	StopErrorPolicy
+)
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case LogErrorPolicy:
+		return "log"
+	case DisableErrorPolicy:
+		return "disable"
+	case StopErrorPolicy:
+		return "stop"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+func ParseErrorPolicy(policyStr string) (ErrorPolicy, error) {
+	for _, policy := range []ErrorPolicy{
+		LogErrorPolicy,
+		DisableErrorPolicy,
+		StopErrorPolicy,
+	} {
+		if strings.EqualFold(policyStr, policy.String()) {
+			return policy, nil
+		}
+	}
+	return LogErrorPolicy, errors.New("unknown error policy")
+}
+
+type HandlerConfig struct {
+	Enabled   bool
+	QueueSize uint `validate:"gte=1"`
+	Overflow  OverflowPolicy
+	OnError   ErrorPolicy
+}
+
+type MQTTConfig struct {
+	Broker          string
+	ClientID        string
+	Username        string
+	Password        string
+	TopicPrefix     string
+	QoS             byte `validate:"lte=2"`
+	Retain          bool
+	Discovery       bool
+	DiscoveryPrefix string
+}
+
+type EnergyConfig struct {
+	MaxGap time.Duration
+}
+
 type ObserveConfig struct {
 	SampleInterval time.Duration
+	Retry          RetryConfig
+	Validation     ValidationConfig
+	Energy         EnergyConfig
+	Handlers       map[string]HandlerConfig `validate:"dive"`
 	Storage        StorageConfig
 	UI             UIConfig
+	MQTT           MQTTConfig
+}
+
+type QuantityConfig struct {
+	Name       string `validate:"required"`
+	Unit       string
+	Expression string `validate:"required"`
+}
+
+// MappingConfig defines how the measurements of the source are mapped to quantities. Path replaces the embedded
+// default mapping file and the Quantities are merged into the mapping by name. Units maps I18N codes to the units of
+// their raw values and is merged into the units of the mapping file.
+type MappingConfig struct {
+	Path       string
+	Services   []string
+	Quantities []QuantityConfig `validate:"dive"`
+	Units      map[string]string
+}
+
+type SnapshotFormat uint8
+
+const (
+	TableSnapshotFormat SnapshotFormat = iota
+	JSONSnapshotFormat
+	CSVSnapshotFormat
+)
+
+func (f SnapshotFormat) String() string {
+	switch f {
+	case TableSnapshotFormat:
+		return "table"
+	case JSONSnapshotFormat:
+		return "json"
+	case CSVSnapshotFormat:
+		return "csv"
+	default:
+		return strconv.Itoa(int(f))
+	}
 }
 
+func ParseSnapshotFormat(formatStr string) (SnapshotFormat, error) {
+	for _, format := range []SnapshotFormat{
+		TableSnapshotFormat,
+		JSONSnapshotFormat,
+		CSVSnapshotFormat,
+	} {
+		if strings.EqualFold(formatStr, format.String()) {
+			return format, nil
+		}
+	}
+	return TableSnapshotFormat, errors.New("unknown snapshot format")
+}
+
+type SnapshotConfig struct {
+	Format SnapshotFormat
+}
+
+type RecordConfig struct {
+	Path string `validate:"required"`
+}
+
+// TotalLabel is the label of the aggregate over all sources.
+const TotalLabel = "total"
+
 type Config struct {
-	Logging  LoggingConfig
+	Logging LoggingConfig
+	Source  SourceConfig
+	// Sources replaces Source to observe multiple devices. Unset fields default to the ones of Source and Redgiant.
+	// They are validated after the defaults are applied.
+	Sources  []SourceConfig `validate:"-"`
 	Redgiant RedgiantConfig
+	Mapping  MappingConfig
 	Observe  ObserveConfig
+	Record   RecordConfig
+	Snapshot SnapshotConfig
 }
 
-func Load() (*Config, error) {
+// Load loads the configuration from the defaults, the config files, and the environment in this order. The overrides
+// map config keys, e.g. "record.path", to values and take precedence over all other sources.
+func Load(overrides map[string]string) (*Config, error) {
 	v := viper.New()
 
 	if err := loadDefaults(v); err != nil {
@@ -144,6 +518,10 @@ func Load() (*Config, error) {
 
 	enableLoadFromEnvVars(v, "TELESCOPE")
 
+	for key, value := range overrides {
+		v.Set(key, value)
+	}
+
 	c := &Config{}
 	if err := v.Unmarshal(c, func(dc *mapstructure.DecoderConfig) {
 
@@ -152,6 +530,12 @@ func Load() (*Config, error) {
 			mapstructure.StringToTimeDurationHookFunc(),
 			stringToZerologLevelHookFunc(),
 			stringToLoggingFormatHookFunc(),
+			stringToSourceKindHookFunc(),
+			stringToValidationActionHookFunc(),
+			stringToOverflowPolicyHookFunc(),
+			stringToErrorPolicyHookFunc(),
+			stringToSnapshotFormatHookFunc(),
+			stringToDatabaseDriverHookFunc(),
 		)
 	}); err != nil {
 		return nil, err
@@ -162,44 +546,212 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	scs := c.SourceConfigs()
+	for _, sc := range scs {
+		if err := validate.Struct(sc); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateSourceLabels(scs); err != nil {
+		return nil, err
+	}
+	if sc := c.Observe.Storage; sc.Retention.Enabled && sc.Database.Timescale.Enabled {
+		return nil, errors.New("storage retention cannot be used with timescale, which applies its own retention")
+	}
+
 	return c, nil
 }
 
+// SourceConfigs returns the configs of all sources.
+func (c Config) SourceConfigs() []SourceConfig {
+	base := c.Source
+	if base.Redgiant == (RedgiantConfig{}) {
+		base.Redgiant = c.Redgiant
+	}
+	if len(c.Sources) == 0 {
+		return []SourceConfig{base}
+	}
+
+	scs := make([]SourceConfig, 0, len(c.Sources))
+	for _, sc := range c.Sources {
+		if sc.Redgiant.Host == "" {
+			sc.Redgiant.Host = base.Redgiant.Host
+		}
+		if sc.Redgiant.Port == 0 {
+			sc.Redgiant.Port = base.Redgiant.Port
+		}
+		if sc.Simulate == (SimulateConfig{}) {
+			sc.Simulate = base.Simulate
+		}
+		if sc.Replay.Speed == 0 {
+			sc.Replay.Speed = base.Replay.Speed
+		}
+		scs = append(scs, sc)
+	}
+	return scs
+}
+
+func validateSourceLabels(scs []SourceConfig) error {
+	if len(scs) == 1 {
+		return nil
+	}
+
+	labels := map[string]bool{}
+	for _, sc := range scs {
+		switch {
+		case sc.Label == "":
+			return errors.New("sources require a label if multiple are configured")
+		case sc.Label == TotalLabel:
+			return fmt.Errorf("source label %s is reserved", TotalLabel)
+		case labels[sc.Label]:
+			return fmt.Errorf("duplicate source label %s", sc.Label)
+		}
+		labels[sc.Label] = true
+	}
+	return nil
+}
+
 func loadDefaults(v *viper.Viper) error {
 	dc := Config{
 		Logging: LoggingConfig{
 			Level:  zerolog.InfoLevel,
 			Format: AutoLoggingFormat,
 		},
+		Source: SourceConfig{
+			Kind: RedgiantSourceKind,
+			Simulate: SimulateConfig{
+				PeakPVPower:         8000,
+				BaseLoadPower:       400,
+				LoadNoise:           150,
+				BatteryCapacity:     10000,
+				MaxBatteryPower:     5000,
+				InitialBatteryLevel: 0.5,
+				MinBatteryLevel:     0.1,
+				Sunrise:             time.Hour * 6,
+				Sunset:              time.Hour * 20,
+			},
+			Replay: ReplayConfig{
+				Speed: 1,
+			},
+		},
 		Redgiant: RedgiantConfig{
 			Host: "127.0.0.1",
 			Port: 8000,
 		},
 		Observe: ObserveConfig{
 			SampleInterval: time.Second * 5,
+			Retry: RetryConfig{
+				InitialInterval: time.Second,
+				MaxInterval:     time.Minute,
+				Multiplier:      2,
+			},
+			Validation: ValidationConfig{
+				Action:       DropValidationAction,
+				AllZero:      true,
+				BatteryLevel: true,
+				EnergyBalance: EnergyBalanceConfig{
+					Enabled:           false,
+					AbsoluteTolerance: 500,
+					RelativeTolerance: 0.2,
+				},
+			},
+			Energy: EnergyConfig{
+				MaxGap: time.Minute * 5,
+			},
+			Handlers: map[string]HandlerConfig{
+				"storage": {
+					Enabled:   true,
+					QueueSize: 100,
+					Overflow:  BlockOverflowPolicy,
+					OnError:   LogErrorPolicy,
+				},
+				"ui": {
+					Enabled:   true,
+					QueueSize: 10,
+					Overflow:  DropOldestOverflowPolicy,
+					OnError:   LogErrorPolicy,
+				},
+				"metrics": {
+					Enabled:   true,
+					QueueSize: 10,
+					Overflow:  DropOldestOverflowPolicy,
+					OnError:   LogErrorPolicy,
+				},
+				"mqtt": {
+					Enabled:   false,
+					QueueSize: 10,
+					Overflow:  DropOldestOverflowPolicy,
+					OnError:   LogErrorPolicy,
+				},
+			},
 			Storage: StorageConfig{
 				Database: DatabaseConfig{
-					Username: "postgres",
-					Host:     "127.0.0.1",
-					Port:     5432,
-					Name:     "postgres",
+					Driver:      PostgresDatabaseDriver,
+					Username:    "postgres",
+					Host:        "127.0.0.1",
+					Port:        5432,
+					Name:        "postgres",
+					Path:        "telescope.db",
+					AutoMigrate: true,
+					Timescale: TimescaleConfig{
+						CompressAfter: time.Hour * 24 * 7,
+					},
 				},
 				Thresholds: ThresholdsConfig{
-					GridPower:    50,
-					BatteryPower: 50,
-					PVPower:      50,
-					LoadPower:    50,
-					BatteryLevel: 0.5e-2,
+					GridPower:             50,
+					BatteryPower:          50,
+					PVPower:               50,
+					LoadPower:             50,
+					BatteryLevel:          0.5e-2,
+					GridImportPower:       50,
+					GridExportPower:       50,
+					BatteryChargePower:    50,
+					BatteryDischargePower: 50,
+					Autarky:               1e-2,
+					SelfConsumptionRate:   1e-2,
 				},
 				ThresholdWeighter: ThresholdWeighterConfig{
 					Start:  time.Minute * 5,
 					Factor: 2,
 				},
+				Retention: RetentionConfig{
+					Interval: time.Hour,
+					Data:     time.Hour * 24 * 30,
+					Rollups: []RollupConfig{
+						{Step: time.Minute * 5, Retention: time.Hour * 24 * 365 * 2},
+						{Step: time.Hour * 24},
+					},
+				},
+				Buffer: BufferConfig{
+					Size:      1000,
+					SpillPath: "telescope-spill.jsonl",
+					Retry: RetryConfig{
+						InitialInterval: time.Second,
+						MaxInterval:     time.Minute,
+						Multiplier:      2,
+						MaxAttempts:     5,
+					},
+				},
 			},
 			UI: UIConfig{
 				Host: "127.0.0.1",
 				Port: 8001,
 			},
+			MQTT: MQTTConfig{
+				Broker:          "tcp://127.0.0.1:1883",
+				ClientID:        "telescope",
+				TopicPrefix:     "telescope",
+				QoS:             0,
+				Retain:          false,
+				Discovery:       true,
+				DiscoveryPrefix: "homeassistant",
+			},
+		},
+		Record: RecordConfig{
+			Path: "telescope.jsonl",
+		},
+		Snapshot: SnapshotConfig{
+			Format: TableSnapshotFormat,
 		},
 	}
 
@@ -301,3 +853,87 @@ func stringToLoggingFormatHookFunc() mapstructure.DecodeHookFuncType {
 		return ParseLoggingFormat(data.(string))
 	}
 }
+
+func stringToSourceKindHookFunc() mapstructure.DecodeHookFuncType {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data any,
+	) (any, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(RedgiantSourceKind) {
+			return data, nil
+		}
+
+		return ParseSourceKind(data.(string))
+	}
+}
+
+func stringToValidationActionHookFunc() mapstructure.DecodeHookFuncType {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data any,
+	) (any, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(DropValidationAction) {
+			return data, nil
+		}
+
+		return ParseValidationAction(data.(string))
+	}
+}
+
+func stringToOverflowPolicyHookFunc() mapstructure.DecodeHookFuncType {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data any,
+	) (any, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(DropOldestOverflowPolicy) {
+			return data, nil
+		}
+
+		return ParseOverflowPolicy(data.(string))
+	}
+}
+
+func stringToErrorPolicyHookFunc() mapstructure.DecodeHookFuncType {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data any,
+	) (any, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(LogErrorPolicy) {
+			return data, nil
+		}
+
+		return ParseErrorPolicy(data.(string))
+	}
+}
+
+func stringToSnapshotFormatHookFunc() mapstructure.DecodeHookFuncType {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data any,
+	) (any, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(TableSnapshotFormat) {
+			return data, nil
+		}
+
+		return ParseSnapshotFormat(data.(string))
+	}
+}
+
+func stringToDatabaseDriverHookFunc() mapstructure.DecodeHookFuncType {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data any,
+	) (any, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(PostgresDatabaseDriver) {
+			return data, nil
+		}
+
+		return ParseDatabaseDriver(data.(string))
+	}
+}
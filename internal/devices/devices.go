@@ -0,0 +1,53 @@
+package devices
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/summary"
+	"github.com/rs/zerolog"
+)
+
+// Run lists the devices of all sources. The device that would be observed is marked with an asterisk.
+func Run(ctx context.Context, c config.Config) error {
+	log := zerolog.New(c.Logging.Format.Writer()).With().Timestamp().Logger().Level(c.Logging.Level)
+
+	scs := c.SourceConfigs()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(scs) > 1 {
+		fmt.Fprint(w, "SOURCE\t")
+	}
+	fmt.Fprintln(w, "ID\tTYPE\tSERIAL\tNAME\tSELECTED")
+
+	for _, sc := range scs {
+		src, err := summary.NewSource(sc, log)
+		if err != nil {
+			return err
+		}
+		defer summary.CloseSource(src)
+		ds, err := src.Devices()
+		if err != nil {
+			return err
+		}
+
+		selected, err := summary.SelectDevice(ds, sc.Device)
+		if err != nil {
+			log.Warn().Err(err).Str("source", sc.Label).Send()
+		}
+		for _, d := range ds {
+			if len(scs) > 1 {
+				fmt.Fprintf(w, "%s\t", sc.Label)
+			}
+			var mark string
+			if err == nil && d.ID == selected.ID {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", d.ID, d.Type, d.Serial, d.Name, mark)
+		}
+	}
+
+	return w.Flush()
+}
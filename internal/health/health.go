@@ -1,6 +1,7 @@
 package health
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -46,7 +47,7 @@ func WaitForHealthy(host string, port uint, d time.Duration) error {
 	}
 }
 
-func Run(c config.Config) error {
+func Run(ctx context.Context, c config.Config) error {
 	if IsHealthy(c.Observe.UI.Host, c.Observe.UI.Port) {
 		return nil
 	} else {
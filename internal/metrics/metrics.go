@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/summary"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+)
+
+const namespace = "telescope"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	sampleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sample_duration_seconds",
+		Help:      "Duration of successfully sampling the source.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 8),
+	}, []string{"device"})
+	sourceErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_errors_total",
+		Help:      "Number of failed attempts to sample the source.",
+	}, []string{"device"})
+	sourceAvailable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_available",
+		Help:      "Whether the source is currently available.",
+	}, []string{"device"})
+	implausibleSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "implausible_samples_total",
+		Help:      "Number of samples that violated a plausibility rule.",
+	}, []string{"rule"})
+	energy = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "energy_watt_hours_total",
+		Help:      "Energy integrated since the start of the process.",
+	}, []string{"device", "counter"})
+	websocketClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_clients",
+		Help:      "Number of connected websocket clients.",
+	})
+
+	quantitiesMu sync.Mutex
+	quantities   = map[summary.Quantity]*prometheus.GaugeVec{}
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		sampleDuration,
+		sourceErrors,
+		sourceAvailable,
+		implausibleSamples,
+		energy,
+		websocketClients,
+	)
+}
+
+func MetricsRouteFunc() (string, string, echo.HandlerFunc) {
+	return http.MethodGet, "/metrics", echo.WrapHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
+
+func ObserveSampleDuration(device string, d time.Duration) {
+	sampleDuration.WithLabelValues(device).Observe(d.Seconds())
+}
+
+func IncSourceErrors(device string) {
+	sourceErrors.WithLabelValues(device).Inc()
+}
+
+func IncImplausibleSamples(rule string) {
+	implausibleSamples.WithLabelValues(rule).Inc()
+}
+
+func SetWebsocketClients(n int) {
+	websocketClients.Set(float64(n))
+}
+
+// quantityGauge returns the gauge for the quantity of the device. It is named after the quantity and its unit, e.g.
+// telescope_grid_power_watts.
+func quantityGauge(q summary.Quantity, device string) prometheus.Gauge {
+	quantitiesMu.Lock()
+	defer quantitiesMu.Unlock()
+
+	g, ok := quantities[q]
+	if !ok {
+		g = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      fmt.Sprintf("%s_%s", q.Name(), q.Unit()),
+			Help:      fmt.Sprintf("Latest value of %s in %s.", q.Name(), q.Unit()),
+		}, []string{"device"})
+		registry.MustRegister(g)
+		quantities[q] = g
+	}
+	return g.WithLabelValues(device)
+}
+
+type MetricsSummaryHandler struct{}
+
+func (sh *MetricsSummaryHandler) Setup(c config.ObserveConfig, log zerolog.Logger, s summary.Summary) error {
+	return sh.Handle(s)
+}
+
+func (sh *MetricsSummaryHandler) Handle(s summary.Summary) error {
+	if s.Device != config.TotalLabel {
+		sourceAvailable.WithLabelValues(s.Device).Set(1)
+	}
+	for q, v := range s.Values {
+		quantityGauge(q, s.Device).Set(float64(v))
+	}
+	for c, v := range s.Energy {
+		energy.WithLabelValues(s.Device, c.Name()).Add(v)
+	}
+	return nil
+}
+
+func (sh *MetricsSummaryHandler) HandleStatus(device string, status summary.Status) error {
+	if status == summary.Available {
+		sourceAvailable.WithLabelValues(device).Set(1)
+	} else {
+		sourceAvailable.WithLabelValues(device).Set(0)
+	}
+	return nil
+}
+
+func (sh *MetricsSummaryHandler) Close() error {
+	return nil
+}
@@ -0,0 +1,85 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/observe/storage"
+)
+
+func open(c config.Config) (*storage.DB, error) {
+	b, err := storage.NewBackendFromConfig(c.Observe.Storage.Database)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewDB(b)
+}
+
+// Up applies the pending migrations up to and including the version. A version of 0 applies all of them.
+func Up(version uint) func(context.Context, config.Config) error {
+	return func(ctx context.Context, c config.Config) error {
+		db, err := open(c)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		ms, err := db.MigrateUp(version)
+		for _, m := range ms {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(ms) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	}
+}
+
+// Down reverts the given number of applied migrations.
+func Down(steps uint) func(context.Context, config.Config) error {
+	return func(ctx context.Context, c config.Config) error {
+		db, err := open(c)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		ms, err := db.MigrateDown(steps)
+		for _, m := range ms {
+			fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(ms) == 0 {
+			fmt.Println("no applied migrations")
+		}
+		return err
+	}
+}
+
+// Status lists all migrations and when they were applied.
+func Status(ctx context.Context, c config.Config) error {
+	db, err := open(c)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	mss, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, ms := range mss {
+		applied := "pending"
+		if !ms.AppliedAt.IsZero() {
+			applied = ms.AppliedAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", ms.Version, ms.Name, applied)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,55 @@
+package observe
+
+import (
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/summary"
+)
+
+// aggregator computes the total over all sources once every available source has reported since the last total.
+// Unavailable sources are left out of the total rather than holding their last known values.
+type aggregator struct {
+	labels      []string
+	latest      map[string]summary.Summary
+	reported    map[string]bool
+	unavailable map[string]bool
+}
+
+func newAggregator(labels []string) *aggregator {
+	return &aggregator{
+		labels:      labels,
+		latest:      map[string]summary.Summary{},
+		reported:    map[string]bool{},
+		unavailable: map[string]bool{},
+	}
+}
+
+func (a *aggregator) setAvailable(label string, available bool) {
+	a.unavailable[label] = !available
+}
+
+// add reports a summary of a source. Implausible summaries count as reported, but only plausible ones are aggregated.
+func (a *aggregator) add(s summary.Summary, plausible bool) (summary.Summary, bool) {
+	a.reported[s.Device] = true
+	if plausible {
+		a.latest[s.Device] = s
+	}
+
+	ss := make([]summary.Summary, 0, len(a.labels))
+	for _, label := range a.labels {
+		if a.unavailable[label] {
+			continue
+		}
+		if !a.reported[label] {
+			return summary.Summary{}, false
+		}
+		if ls, ok := a.latest[label]; ok {
+			ss = append(ss, ls)
+		}
+	}
+	clear(a.reported)
+
+	if len(ss) == 0 {
+		return summary.Summary{}, false
+	}
+	return summary.Aggregate(config.TotalLabel, ss), true
+}
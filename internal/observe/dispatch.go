@@ -0,0 +1,141 @@
+package observe
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/rs/zerolog"
+)
+
+type event func(SummaryHandler) error
+
+// worker runs the events for a single SummaryHandler on its own goroutine.
+type worker struct {
+	name     string
+	th       SummaryHandler
+	c        config.HandlerConfig
+	log      zerolog.Logger
+	queue    chan event
+	errs     chan<- error
+	disabled atomic.Bool
+	done     chan struct{}
+}
+
+func (w *worker) push(e event) {
+	if w.disabled.Load() {
+		return
+	}
+
+	if w.c.Overflow == config.BlockOverflowPolicy {
+		w.queue <- e
+		return
+	}
+
+	for {
+		select {
+		case w.queue <- e:
+			return
+		default:
+		}
+
+		select {
+		case <-w.queue:
+			w.log.Warn().Msg("queue full, dropping oldest event")
+		default:
+		}
+	}
+}
+
+func (w *worker) run() {
+	defer close(w.done)
+
+	for e := range w.queue {
+		if w.disabled.Load() {
+			continue
+		}
+
+		if err := e(w.th); err != nil {
+			w.fail(err)
+		}
+	}
+
+	if err := w.th.Close(); err != nil {
+		w.log.Error().Err(err).Msg("closing handler failed")
+	}
+}
+
+func (w *worker) fail(err error) {
+	log := w.log.Error().Err(err).Stringer("policy", w.c.OnError)
+	switch w.c.OnError {
+	case config.DisableErrorPolicy:
+		w.disabled.Store(true)
+		log.Msg("handler failed and is disabled")
+	case config.StopErrorPolicy:
+		log.Msg("handler failed")
+		select {
+		case w.errs <- fmt.Errorf("handler %s: %w", w.name, err):
+		default:
+		}
+	default:
+		log.Msg("handler failed")
+	}
+}
+
+// dispatcher fans out events to all SummaryHandlers. Each handler has a bounded queue, such that a slow handler
+// neither delays the others nor the sampling.
+type dispatcher struct {
+	workers []*worker
+	errs    chan error
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{errs: make(chan error, 1)}
+}
+
+func (d *dispatcher) add(name string, th SummaryHandler, c config.HandlerConfig, log zerolog.Logger) {
+	if _, ok := th.(LosslessHandler); ok && c.Overflow != config.BlockOverflowPolicy {
+		log.Warn().Str("handler", name).Stringer("overflow", c.Overflow).Msg("handler must not drop events, using block overflow policy")
+		c.Overflow = config.BlockOverflowPolicy
+	}
+
+	w := &worker{
+		name:  name,
+		th:    th,
+		c:     c,
+		log:   log.With().Str("handler", name).Logger(),
+		queue: make(chan event, max(c.QueueSize, 1)),
+		errs:  d.errs,
+		done:  make(chan struct{}),
+	}
+	d.workers = append(d.workers, w)
+
+	go w.run()
+}
+
+func (d *dispatcher) dispatch(e event) {
+	for _, w := range d.workers {
+		w.push(e)
+	}
+}
+
+// close waits until all handlers processed their queued events and closes them afterwards.
+func (d *dispatcher) close() {
+	for _, w := range d.workers {
+		close(w.queue)
+	}
+	for _, w := range d.workers {
+		<-w.done
+	}
+	d.workers = nil
+}
+
+// err returns the first error of a handler with the stop error policy or nil if there is none.
+func (d *dispatcher) err() error {
+	select {
+	case err := <-d.errs:
+		return err
+	default:
+		return nil
+	}
+}
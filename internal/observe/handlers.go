@@ -0,0 +1,65 @@
+package observe
+
+import (
+	"fmt"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/metrics"
+	"github.com/pmeier/telescope/internal/observe/mqtt"
+	"github.com/pmeier/telescope/internal/observe/storage"
+	"github.com/pmeier/telescope/internal/observe/ui"
+	"github.com/rs/zerolog"
+)
+
+var summaryHandlerFactories = map[string]func() SummaryHandler{}
+
+// RegisterSummaryHandler makes a SummaryHandler available under the given name. The handler is used if it is enabled
+// in the observe.handlers config section.
+func RegisterSummaryHandler(name string, newHandler func() SummaryHandler) {
+	if _, ok := summaryHandlerFactories[name]; ok {
+		panic(fmt.Sprintf("summary handler %s is already registered", name))
+	}
+	summaryHandlerFactories[name] = newHandler
+}
+
+func init() {
+	RegisterSummaryHandler("storage", func() SummaryHandler { return &storage.StorageSummaryHandler{} })
+	RegisterSummaryHandler("ui", func() SummaryHandler { return &ui.UISummaryHandler{} })
+	RegisterSummaryHandler("metrics", func() SummaryHandler { return &metrics.MetricsSummaryHandler{} })
+	RegisterSummaryHandler("mqtt", func() SummaryHandler { return &mqtt.MQTTSummaryHandler{} })
+}
+
+func summaryHandlers(hcs map[string]config.HandlerConfig) (map[string]SummaryHandler, error) {
+	ths := map[string]SummaryHandler{}
+	for name, hc := range hcs {
+		if !hc.Enabled {
+			continue
+		}
+
+		newHandler, ok := summaryHandlerFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown summary handler %s", name)
+		}
+		ths[name] = newHandler()
+	}
+	return ths, nil
+}
+
+// openDB opens the database if the storage handler is enabled. If that fails, the storage handler is disabled like for
+// a failed setup.
+func openDB(c config.ObserveConfig, ths map[string]SummaryHandler, log zerolog.Logger) (*storage.DB, error) {
+	if _, ok := ths["storage"]; !ok {
+		return nil, nil
+	}
+
+	db, err := storage.NewDBFromConfig(c.Storage.Database)
+	if err != nil {
+		if c.Handlers["storage"].OnError == config.StopErrorPolicy {
+			return nil, fmt.Errorf("handler storage: %w", err)
+		}
+		log.Error().Err(err).Str("handler", "storage").Msg("setup failed, handler is disabled")
+		delete(ths, "storage")
+		return nil, nil
+	}
+	return db, nil
+}
@@ -0,0 +1,250 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pahomqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/summary"
+	"github.com/rs/zerolog"
+)
+
+const timeout = time.Second * 10
+
+const (
+	online  = "online"
+	offline = "offline"
+)
+
+// MQTTSummaryHandler publishes the value of every quantity on <prefix>/<device>/<quantity name> and its unit on
+// <prefix>/<device>/<quantity name>/unit. The device level is omitted for a single unlabeled source. The availability
+// of each device is published on <prefix>/<device>/status and the one of telescope itself on <prefix>/status.
+type MQTTSummaryHandler struct {
+	log    zerolog.Logger
+	c      config.MQTTConfig
+	client pahomqtt.Client
+
+	mu          sync.Mutex
+	announced   map[string]bool
+	unavailable map[string]bool
+}
+
+func (sh *MQTTSummaryHandler) Setup(c config.ObserveConfig, log zerolog.Logger, s summary.Summary) error {
+	mc := c.MQTT
+	sh.c = mc
+	sh.log = log.With().Str("broker", mc.Broker).Logger()
+	sh.announced = map[string]bool{}
+	sh.unavailable = map[string]bool{}
+
+	opts := pahomqtt.NewClientOptions().
+		AddBroker(mc.Broker).
+		SetClientID(mc.ClientID).
+		SetUsername(mc.Username).
+		SetPassword(mc.Password).
+		SetAutoReconnect(true).
+		SetWill(sh.topic("", "status"), offline, mc.QoS, true).
+		SetOnConnectHandler(func(pahomqtt.Client) {
+			sh.log.Info().Msg("connected")
+			if err := sh.announce(); err != nil {
+				sh.log.Error().Err(err).Msg("announcing failed")
+			}
+		}).
+		SetConnectionLostHandler(func(_ pahomqtt.Client, err error) {
+			sh.log.Warn().Err(err).Msg("connection lost")
+		})
+
+	sh.client = pahomqtt.NewClient(opts)
+	if err := wait(sh.client.Connect()); err != nil {
+		return err
+	}
+
+	return sh.Handle(s)
+}
+
+func (sh *MQTTSummaryHandler) Handle(s summary.Summary) error {
+	sh.mu.Lock()
+	announced := sh.announced[s.Device]
+	sh.announced[s.Device] = true
+	sh.mu.Unlock()
+
+	errs := []error{}
+	if !announced {
+		errs = append(errs, sh.announceDevice(s.Device))
+	}
+	for q, v := range s.Values {
+		value := strconv.FormatFloat(float64(v), 'f', -1, 32)
+		errs = append(errs, sh.publish(sh.topic(s.Device, q.Name()), value, sh.c.Retain))
+	}
+	return errors.Join(errs...)
+}
+
+func (sh *MQTTSummaryHandler) HandleStatus(device string, status summary.Status) error {
+	sh.mu.Lock()
+	sh.unavailable[device] = status == summary.Unavailable
+	sh.mu.Unlock()
+
+	return sh.publishStatus(device)
+}
+
+func (sh *MQTTSummaryHandler) Close() error {
+	sh.mu.Lock()
+	topics := []string{sh.topic("", "status")}
+	for device := range sh.announced {
+		topics = append(topics, sh.topic(device, "status"))
+	}
+	sh.mu.Unlock()
+
+	errs := []error{}
+	for _, topic := range slices.Compact(slices.Sorted(slices.Values(topics))) {
+		errs = append(errs, sh.publish(topic, offline, true))
+	}
+	sh.client.Disconnect(uint(timeout.Milliseconds()))
+	return errors.Join(errs...)
+}
+
+// announce publishes the availability of telescope and announces all devices seen so far. It is called on every
+// (re)connect, since the broker might have lost the retained messages.
+func (sh *MQTTSummaryHandler) announce() error {
+	sh.mu.Lock()
+	devices := slices.Sorted(maps.Keys(sh.announced))
+	sh.mu.Unlock()
+
+	errs := []error{}
+	if !slices.Contains(devices, "") {
+		errs = append(errs, sh.publish(sh.topic("", "status"), online, true))
+	}
+	for _, device := range devices {
+		errs = append(errs, sh.announceDevice(device))
+	}
+	return errors.Join(errs...)
+}
+
+// announceDevice publishes everything that has to be present before the first value of a device, i.e. the units, the
+// Home Assistant discovery configs, and the availability.
+func (sh *MQTTSummaryHandler) announceDevice(device string) error {
+	errs := []error{}
+	for _, q := range summary.Quantities() {
+		errs = append(errs, sh.publish(sh.topic(device, q.Name(), "unit"), q.Unit(), true))
+
+		if !sh.c.Discovery {
+			continue
+		}
+		payload, err := json.Marshal(sh.discoveryConfig(device, q))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		topic := strings.Join([]string{sh.c.DiscoveryPrefix, "sensor", sh.nodeID(device), q.Name(), "config"}, "/")
+		errs = append(errs, sh.publish(topic, string(payload), true))
+	}
+	errs = append(errs, sh.publishStatus(device))
+	return errors.Join(errs...)
+}
+
+func (sh *MQTTSummaryHandler) publishStatus(device string) error {
+	sh.mu.Lock()
+	unavailable := sh.unavailable[device]
+	sh.mu.Unlock()
+
+	status := online
+	if unavailable {
+		status = offline
+	}
+	return sh.publish(sh.topic(device, "status"), status, true)
+}
+
+func (sh *MQTTSummaryHandler) publish(topic string, payload string, retained bool) error {
+	return wait(sh.client.Publish(topic, sh.c.QoS, retained, payload))
+}
+
+func (sh *MQTTSummaryHandler) topic(device string, parts ...string) string {
+	levels := []string{sh.c.TopicPrefix}
+	if device != "" {
+		levels = append(levels, device)
+	}
+	return strings.Join(append(levels, parts...), "/")
+}
+
+// nodeID identifies the device towards Home Assistant.
+func (sh *MQTTSummaryHandler) nodeID(device string) string {
+	if device == "" {
+		return sh.c.ClientID
+	}
+	return fmt.Sprintf("%s_%s", sh.c.ClientID, device)
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+type discoveryAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// https://www.home-assistant.io/integrations/sensor.mqtt/
+type discoveryConfig struct {
+	Name              string                  `json:"name"`
+	UniqueID          string                  `json:"unique_id"`
+	StateTopic        string                  `json:"state_topic"`
+	Availability      []discoveryAvailability `json:"availability"`
+	AvailabilityMode  string                  `json:"availability_mode,omitempty"`
+	UnitOfMeasurement string                  `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string                  `json:"device_class,omitempty"`
+	StateClass        string                  `json:"state_class"`
+	ValueTemplate     string                  `json:"value_template,omitempty"`
+	Device            discoveryDevice         `json:"device"`
+}
+
+func (sh *MQTTSummaryHandler) discoveryConfig(device string, q summary.Quantity) discoveryConfig {
+	nodeID := sh.nodeID(device)
+	dc := discoveryConfig{
+		Name:         strings.ReplaceAll(q.Name(), "_", " "),
+		UniqueID:     fmt.Sprintf("%s_%s", nodeID, q.Name()),
+		StateTopic:   sh.topic(device, q.Name()),
+		Availability: []discoveryAvailability{{Topic: sh.topic("", "status")}},
+		StateClass:   "measurement",
+		Device: discoveryDevice{
+			Identifiers:  []string{nodeID},
+			Name:         strings.ReplaceAll(nodeID, "_", " "),
+			Manufacturer: "Sungrow",
+		},
+	}
+	if device != "" {
+		dc.Availability = append(dc.Availability, discoveryAvailability{Topic: sh.topic(device, "status")})
+		dc.AvailabilityMode = "all"
+	}
+
+	switch q.Unit() {
+	case "watts":
+		dc.UnitOfMeasurement = "W"
+		dc.DeviceClass = "power"
+	case "ratio":
+		// Home Assistant expects percentages rather than ratios
+		dc.UnitOfMeasurement = "%"
+		dc.ValueTemplate = "{{ (value | float * 100) | round(1) }}"
+		if q == summary.BatteryLevel {
+			dc.DeviceClass = "battery"
+		}
+	default:
+		dc.UnitOfMeasurement = q.Unit()
+	}
+
+	return dc
+}
+
+func wait(t pahomqtt.Token) error {
+	if !t.WaitTimeout(timeout) {
+		return errors.New("timeout")
+	}
+	return t.Error()
+}
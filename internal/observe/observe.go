@@ -1,78 +1,265 @@
 package observe
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
 	"time"
 
-	"github.com/pmeier/redgiant"
 	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/metrics"
 	"github.com/pmeier/telescope/internal/observe/storage"
-	"github.com/pmeier/telescope/internal/observe/ui"
 	"github.com/pmeier/telescope/internal/summary"
 
-	rghttp "github.com/pmeier/redgiant/http"
-
 	"github.com/rs/zerolog"
 )
 
 type SummaryHandler interface {
 	Setup(config.ObserveConfig, zerolog.Logger, summary.Summary) error
 	Handle(summary.Summary) error
+	// Close is called once after the last Handle call and should release all resources.
+	Close() error
 }
 
-func summaryHandlers() []SummaryHandler {
-	return []SummaryHandler{
-		&storage.StorageSummaryHandler{},
-		&ui.UISummaryHandler{},
-	}
+// StatusHandler is optionally implemented by a SummaryHandler to be notified if the availability of a source changes.
+type StatusHandler interface {
+	HandleStatus(device string, status summary.Status) error
+}
+
+// LosslessHandler is optionally implemented by a SummaryHandler that must not miss any event, e.g. because it
+// accumulates state across events. Its queue always uses the block overflow policy.
+type LosslessHandler interface {
+	Lossless()
 }
 
-func Run(c config.Config) error {
-	log := zerolog.New(c.Logging.Format.Writer()).With().Timestamp().Logger().Level(c.Logging.Level)
+// DBHandler is optionally implemented by a SummaryHandler that uses the database of the storage. SetDB is called before
+// Setup with the database, which is nil if the storage handler is not enabled.
+type DBHandler interface {
+	SetDB(db *storage.DB)
+}
 
-	rg := rghttp.NewRedgiant(c.Redgiant.Host, c.Redgiant.Port, redgiant.WithLogger(log))
+func Run(ctx context.Context, c config.Config) error {
+	log := newLogger(c)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	deviceID, err := summary.GetDeviceID(rg)
+	m, err := summary.NewMappingFromConfig(c.Mapping)
 	if err != nil {
 		return err
 	}
 
-	ths := summaryHandlers()
+	scs := c.SourceConfigs()
+	sps := make([]*sampler, 0, len(scs))
+	igs := make(map[string]*summary.Integrator, len(scs)+1)
+	labels := make([]string, 0, len(scs))
+	for _, sc := range scs {
+		src, err := summary.NewSource(sc, log)
+		if err != nil {
+			return err
+		}
+		defer summary.CloseSource(src)
+		sps = append(sps, newSampler(sc, src, m, c.Observe.Retry, log.With().Str("device", sc.Label).Logger()))
+		igs[sc.Label] = summary.NewIntegrator(c.Observe.Energy.MaxGap)
+		labels = append(labels, sc.Label)
+	}
+	v := summary.NewValidatorFromConfig(c.Observe.Validation)
+
+	var ag *aggregator
+	if len(sps) > 1 {
+		ag = newAggregator(labels)
+		igs[config.TotalLabel] = summary.NewIntegrator(c.Observe.Energy.MaxGap)
+	}
 
-	s, err := summary.Compute(rg, deviceID)
+	ths, err := summaryHandlers(c.Observe.Handlers)
 	if err != nil {
 		return err
 	}
+	if len(ths) == 0 {
+		log.Warn().Msg("no summary handlers enabled")
+	}
+
+	// the database is opened once for all handlers, such that it is only migrated and configured once. It is closed
+	// after the handlers.
+	db, err := openDB(c.Observe, ths, log)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
 	for _, th := range ths {
-		if err := th.Setup(c.Observe, log, s); err != nil {
-			return err
+		if dh, ok := th.(DBHandler); ok {
+			dh.SetDB(db)
 		}
 	}
 
-	for range ticks(c.Observe.SampleInterval) {
-		s, err := summary.Compute(rg, deviceID)
-		if err != nil {
+	d := newDispatcher()
+	defer d.close()
+	var setup bool
+
+	emit := func(s summary.Summary) error {
+		if setup {
+			d.dispatch(func(th SummaryHandler) error {
+				return th.Handle(s)
+			})
+			return nil
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(ths)) {
+			hc := c.Observe.Handlers[name]
+			if err := ths[name].Setup(c.Observe, log, s); err != nil {
+				if hc.OnError == config.StopErrorPolicy {
+					return fmt.Errorf("handler %s: %w", name, err)
+				}
+				log.Error().Err(err).Str("handler", name).Msg("setup failed, handler is disabled")
+				continue
+			}
+			d.add(name, ths[name], hc, log)
+		}
+		setup = true
+		return nil
+	}
+
+	samples := make(chan sampled)
+	statuses := make(chan statusChange)
+	var wg sync.WaitGroup
+	for _, sp := range sps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sp.run(ctx, sampleInterval(c, sp.src), samples, statuses)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(samples)
+	}()
+
+	for {
+		var (
+			r  sampled
+			ok bool
+		)
+		select {
+		case sc := <-statuses:
+			d.dispatch(func(th SummaryHandler) error {
+				if sh, ok := th.(StatusHandler); ok {
+					return sh.HandleStatus(sc.label, sc.status)
+				}
+				return nil
+			})
+			if ag != nil {
+				ag.setAvailable(sc.label, sc.status == summary.Available)
+			}
+			continue
+		case r, ok = <-samples:
+		}
+		if !ok {
+			break
+		}
+
+		if err := d.err(); err != nil {
 			return err
 		}
-		// FIXME: check if all values are 0 and continue if so
 
-		for _, th := range ths {
-			// FIXME goroutine?
-			if err := th.Handle(s); err != nil {
+		if errors.Is(r.err, io.EOF) {
+			log.Info().Str("device", r.label).Msg("source exhausted")
+			if ag != nil {
+				ag.setAvailable(r.label, false)
+			}
+			continue
+		} else if r.err != nil {
+			return r.err
+		}
+
+		s := r.s
+		plausible := true
+		if violations := v.Validate(s); len(violations) > 0 {
+			plausible = false
+			for _, rule := range violations {
+				metrics.IncImplausibleSamples(rule)
+			}
+			log.Warn().
+				Str("device", s.Device).
+				Strs("violations", violations).
+				Interface("counts", v.Counts()).
+				Stringer("action", c.Observe.Validation.Action).
+				Msg("implausible sample")
+			s.Violations = violations
+		} else {
+			s.Energy = igs[s.Device].Integrate(s)
+		}
+
+		if plausible || c.Observe.Validation.Action != config.DropValidationAction {
+			if err := emit(s); err != nil {
+				return err
+			}
+		}
+
+		if ag == nil {
+			continue
+		}
+		if total, ok := ag.add(s, plausible); ok {
+			total.Energy = igs[total.Device].Integrate(total)
+			if err := emit(total); err != nil {
 				return err
 			}
 		}
 	}
 
+	log.Info().Msg("shutting down")
 	return nil
 }
 
-func ticks(d time.Duration) <-chan time.Time {
+func newLogger(c config.Config) zerolog.Logger {
+	return zerolog.New(c.Logging.Format.Writer()).With().Timestamp().Logger().Level(c.Logging.Level)
+}
+
+// sampleInterval returns zero for sources that pace the sampling themselves.
+func sampleInterval(c config.Config, src summary.Source) time.Duration {
+	if _, ok := src.(summary.Clock); ok {
+		return 0
+	}
+	return c.Observe.SampleInterval
+}
+
+// ticks sends the current time every d until the context is done. For d <= 0, ticks are sent as fast as they are
+// received.
+func ticks(ctx context.Context, d time.Duration) <-chan time.Time {
 	t := make(chan time.Time)
 
 	go func() {
-		t <- time.Now()
-		for now := range time.NewTicker(d).C {
-			t <- now
+		defer close(t)
+
+		var tc <-chan time.Time
+		if d > 0 {
+			ticker := time.NewTicker(d)
+			defer ticker.Stop()
+			tc = ticker.C
+		}
+
+		now := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t <- now:
+			}
+
+			if tc == nil {
+				now = time.Now()
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case now = <-tc:
+			}
 		}
 	}()
 
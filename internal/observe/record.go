@@ -0,0 +1,54 @@
+package observe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/summary"
+)
+
+// Record samples the configured source like Run, but only writes the raw responses to the recording file.
+func Record(ctx context.Context, c config.Config) error {
+	log := newLogger(c)
+
+	f, err := os.Create(c.Record.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scs := c.SourceConfigs()
+	if len(scs) > 1 {
+		return errors.New("recording multiple sources is not supported")
+	}
+	src, err := summary.NewSource(scs[0], log)
+	if err != nil {
+		return err
+	}
+	defer summary.CloseSource(src)
+	m, err := summary.NewMappingFromConfig(c.Mapping)
+	if err != nil {
+		return err
+	}
+	sp := newSampler(scs[0], summary.NewRecordingSource(src, f), m, c.Observe.Retry, log)
+
+	log.Info().Str("path", c.Record.Path).Msg("recording")
+	for range ticks(ctx, sampleInterval(c, src)) {
+		s, err := sp.sample(ctx, nil)
+		if errors.Is(err, io.EOF) {
+			log.Info().Msg("source exhausted")
+			return nil
+		} else if errors.Is(err, context.Canceled) {
+			break
+		} else if err != nil {
+			return err
+		}
+		log.Debug().Time("timestamp", s.Timestamp).Msg("recorded")
+	}
+
+	log.Info().Msg("stopped")
+	return nil
+}
@@ -0,0 +1,146 @@
+package observe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/metrics"
+	"github.com/pmeier/telescope/internal/summary"
+	"github.com/rs/zerolog"
+)
+
+type sampler struct {
+	label    string
+	src      summary.Source
+	dc       config.DeviceConfig
+	m        *summary.Mapping
+	c        config.RetryConfig
+	log      zerolog.Logger
+	deviceID int
+	status   summary.Status
+}
+
+func newSampler(sc config.SourceConfig, src summary.Source, m *summary.Mapping, c config.RetryConfig, log zerolog.Logger) *sampler {
+	return &sampler{label: sc.Label, src: src, dc: sc.Device, m: m, c: c, log: log, status: summary.Available}
+}
+
+// sampled is a summary of a source or the error that ended its sampling.
+type sampled struct {
+	label string
+	s     summary.Summary
+	err   error
+}
+
+type statusChange struct {
+	label  string
+	status summary.Status
+}
+
+// run samples the source every d and sends the results until the context is done. Sampling stops after the first
+// error, e.g. if the source is exhausted.
+func (sp *sampler) run(ctx context.Context, d time.Duration, samples chan<- sampled, statuses chan<- statusChange) {
+	onStatus := func(status summary.Status) error {
+		select {
+		case statuses <- statusChange{label: sp.label, status: status}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for range ticks(ctx, d) {
+		s, err := sp.sample(ctx, onStatus)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		select {
+		case samples <- sampled{label: sp.label, s: s, err: err}:
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sample computes a summary and retries failed attempts with exponential backoff. The device ID is discovered again
+// after every failure, since it might have changed after the source reconnected. onStatus is called whenever the
+// availability of the source changes and may be nil. Retrying stops if the context is done.
+func (sp *sampler) sample(ctx context.Context, onStatus func(summary.Status) error) (summary.Summary, error) {
+	if c, ok := sp.src.(summary.Clock); ok {
+		if err := c.Wait(ctx); err != nil {
+			return summary.Summary{}, err
+		}
+	}
+
+	var attempt uint
+	for {
+		start := time.Now()
+		s, err := sp.compute()
+		if err == nil {
+			metrics.ObserveSampleDuration(sp.label, time.Since(start))
+			return s, sp.setStatus(summary.Available, onStatus)
+		} else if errors.Is(err, io.EOF) {
+			return s, err
+		}
+		metrics.IncSourceErrors(sp.label)
+
+		attempt++
+		if sp.c.MaxAttempts > 0 && attempt >= sp.c.MaxAttempts {
+			return s, err
+		}
+
+		if err := sp.setStatus(summary.Unavailable, onStatus); err != nil {
+			return s, err
+		}
+
+		d := sp.backoff(attempt)
+		sp.log.Warn().Err(err).Uint("attempt", attempt).Dur("retry_in", d).Msg("sampling failed")
+		select {
+		case <-ctx.Done():
+			return s, ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+func (sp *sampler) compute() (summary.Summary, error) {
+	if sp.deviceID == 0 {
+		deviceID, err := summary.GetDeviceID(sp.src, sp.dc)
+		if err != nil {
+			return summary.Summary{}, err
+		}
+		sp.deviceID = deviceID
+	}
+
+	s, err := sp.m.Compute(sp.src, sp.deviceID)
+	if err != nil {
+		sp.deviceID = 0
+	}
+	s.Device = sp.label
+	return s, err
+}
+
+func (sp *sampler) backoff(attempt uint) time.Duration {
+	d := float64(sp.c.InitialInterval) * math.Pow(sp.c.Multiplier, float64(attempt-1))
+	return time.Duration(min(d, float64(sp.c.MaxInterval)))
+}
+
+func (sp *sampler) setStatus(status summary.Status, onStatus func(summary.Status) error) error {
+	if status == sp.status {
+		return nil
+	}
+	sp.status = status
+
+	sp.log.Info().Stringer("status", status).Msg("source status changed")
+	if onStatus == nil {
+		return nil
+	}
+	return onStatus(status)
+}
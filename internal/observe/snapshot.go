@@ -0,0 +1,137 @@
+package observe
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/summary"
+)
+
+// Snapshot computes a single summary of every source and prints them in the configured format. Unlike Run, failed
+// attempts are not retried and no handlers are started.
+func Snapshot(ctx context.Context, c config.Config) error {
+	log := newLogger(c)
+
+	m, err := summary.NewMappingFromConfig(c.Mapping)
+	if err != nil {
+		return err
+	}
+	v := summary.NewValidatorFromConfig(c.Observe.Validation)
+
+	scs := c.SourceConfigs()
+	ss := make([]summary.Summary, 0, len(scs)+1)
+	for _, sc := range scs {
+		src, err := summary.NewSource(sc, log)
+		if err != nil {
+			return err
+		}
+		defer summary.CloseSource(src)
+		s, err := snapshot(ctx, src, sc.Device, m)
+		if err != nil {
+			return fmt.Errorf("source %s: %w", sc.Label, err)
+		}
+		s.Device = sc.Label
+		s.Violations = v.Validate(s)
+		ss = append(ss, s)
+	}
+	if len(ss) > 1 {
+		ss = append(ss, summary.Aggregate(config.TotalLabel, ss))
+	}
+
+	switch c.Snapshot.Format {
+	case config.JSONSnapshotFormat:
+		return writeSnapshotJSON(os.Stdout, ss)
+	case config.CSVSnapshotFormat:
+		return writeSnapshotCSV(os.Stdout, ss)
+	default:
+		return writeSnapshotTable(os.Stdout, ss)
+	}
+}
+
+// writeSnapshotJSON writes a single summary as object and multiple ones as array.
+// snapshot computes a single summary of the source. The sources cannot be cancelled, such that the summary is abandoned
+// if the context is done first.
+func snapshot(ctx context.Context, src summary.Source, dc config.DeviceConfig, m *summary.Mapping) (summary.Summary, error) {
+	type result struct {
+		s   summary.Summary
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		deviceID, err := summary.GetDeviceID(src, dc)
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+		s, err := m.Compute(src, deviceID)
+		ch <- result{s: s, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return summary.Summary{}, ctx.Err()
+	case r := <-ch:
+		return r.s, r.err
+	}
+}
+
+func writeSnapshotJSON(w io.Writer, ss []summary.Summary) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if len(ss) == 1 {
+		return enc.Encode(ss[0])
+	}
+	return enc.Encode(ss)
+}
+
+func writeSnapshotCSV(w io.Writer, ss []summary.Summary) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "device", "quantity", "value", "unit"})
+	for _, s := range ss {
+		for _, q := range summary.Quantities() {
+			v, ok := s.Values[q]
+			if !ok {
+				continue
+			}
+			cw.Write([]string{
+				s.Timestamp.Format(time.RFC3339),
+				s.Device,
+				q.Name(),
+				strconv.FormatFloat(float64(v), 'f', -1, 32),
+				q.Unit(),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeSnapshotTable(w io.Writer, ss []summary.Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, s := range ss {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		if s.Device != "" {
+			fmt.Fprintf(tw, "DEVICE\t%s\n", s.Device)
+		}
+		fmt.Fprintf(tw, "TIMESTAMP\t%s\n", s.Timestamp.Format(time.RFC3339))
+		for _, rule := range s.Violations {
+			fmt.Fprintf(tw, "VIOLATION\t%s\n", rule)
+		}
+		for _, q := range summary.Quantities() {
+			if v, ok := s.Values[q]; ok {
+				fmt.Fprintf(tw, "%s\t%.6g\t%s\n", q.Name(), v, q.Unit())
+			}
+		}
+	}
+	return tw.Flush()
+}
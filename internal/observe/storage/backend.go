@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/pmeier/telescope/internal/config"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Backend is a database the storage can use. All backends share the same schema, such that only opening the database
+// and the few dialect specific SQL expressions differ.
+type Backend interface {
+	Dialector() gorm.Dialector
+	// Migrations returns the directories of the embedded migrations of the backend. Versions are unique across them.
+	Migrations() []string
+	// Configure prepares the database once the schema is up to date.
+	Configure(db *gorm.DB) error
+	// UnixTime returns an SQL expression for the timestamp column as Unix time. Timestamps are read this way, since
+	// not all backends return them in a format that can be scanned.
+	UnixTime(column string) string
+	// TimeBucket returns an SQL expression for the start of the bucket of the given size the timestamp column falls
+	// into as Unix time.
+	TimeBucket(column string, step time.Duration) clause.Expr
+}
+
+func NewBackendFromConfig(c config.DatabaseConfig) (Backend, error) {
+	switch c.Driver {
+	case config.PostgresDatabaseDriver:
+		if c.Password == "" {
+			return nil, errors.New("database password is required")
+		}
+		pb := PostgresBackend{Host: c.Host, Port: c.Port, Username: c.Username, Password: c.Password, Name: c.Name}
+		if c.Timescale.Enabled {
+			if c.Timescale.Retention > 0 && c.Timescale.Retention <= timescaleRefreshWindow {
+				return nil, fmt.Errorf("timescale retention has to be longer than %s to keep the aggregates", timescaleRefreshWindow)
+			}
+			return &TimescaleBackend{
+				PostgresBackend: pb,
+				CompressAfter:   c.Timescale.CompressAfter,
+				Retention:       c.Timescale.Retention,
+			}, nil
+		}
+		return &pb, nil
+	case config.SQLiteDatabaseDriver:
+		if c.Timescale.Enabled {
+			return nil, errors.New("timescale requires the postgres driver")
+		}
+		if c.Path == "" {
+			return nil, errors.New("database path is required")
+		}
+		return &SQLiteBackend{Path: c.Path}, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %s", c.Driver)
+	}
+}
+
+type PostgresBackend struct {
+	Host     string
+	Port     uint
+	Username string
+	Password string
+	Name     string
+}
+
+func (b *PostgresBackend) Dialector() gorm.Dialector {
+	return postgres.Open(b.dsn())
+}
+
+func (b *PostgresBackend) Migrations() []string {
+	return []string{"postgres"}
+}
+
+func (b *PostgresBackend) Configure(db *gorm.DB) error {
+	return nil
+}
+
+func (b *PostgresBackend) UnixTime(column string) string {
+	return fmt.Sprintf("extract(epoch FROM %s)", column)
+}
+
+func (b *PostgresBackend) TimeBucket(column string, step time.Duration) clause.Expr {
+	return gorm.Expr(fmt.Sprintf("floor(extract(epoch FROM %s) / ?) * ?", column), step.Seconds(), step.Seconds())
+}
+
+func (b *PostgresBackend) dsn() string {
+	dsnKeyValues := map[string]string{
+		"host":     b.Host,
+		"port":     strconv.Itoa(int(b.Port)),
+		"user":     b.Username,
+		"password": b.Password,
+		"dbname":   b.Name,
+		"sslmode":  "disable",
+	}
+	dsnPairs := make([]string, 0, len(dsnKeyValues))
+	for key, value := range dsnKeyValues {
+		dsnPairs = append(dsnPairs, fmt.Sprintf("%s=%s", key, value))
+	}
+	return strings.Join(dsnPairs, " ")
+}
+
+// SQLiteBackend stores everything in a local file. It does not require cgo.
+type SQLiteBackend struct {
+	Path string
+}
+
+func (b *SQLiteBackend) Dialector() gorm.Dialector {
+	// the busy timeout avoids failing writes while the UI reads from its own connection
+	return sqlite.Open(fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", b.Path))
+}
+
+func (b *SQLiteBackend) Migrations() []string {
+	return []string{"sqlite"}
+}
+
+func (b *SQLiteBackend) Configure(db *gorm.DB) error {
+	return nil
+}
+
+func (b *SQLiteBackend) UnixTime(column string) string {
+	return fmt.Sprintf("CAST(strftime('%%s', %s) AS INTEGER)", column)
+}
+
+func (b *SQLiteBackend) TimeBucket(column string, step time.Duration) clause.Expr {
+	// SQLite only supports buckets of whole seconds
+	s := int64(step / time.Second)
+	return gorm.Expr(fmt.Sprintf("CAST(strftime('%%s', %s) AS INTEGER) / ? * ?", column), s, s)
+}
@@ -1,43 +1,73 @@
 package storage
 
 import (
+	"cmp"
+	"database/sql"
+	"errors"
 	"fmt"
-	"strconv"
-	"strings"
+	"slices"
 	"time"
 
-	"gorm.io/driver/postgres"
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/summary"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
 )
 
 type DB struct {
 	*gorm.DB
+	b Backend
 }
 
-func NewDB(host string, port uint, username string, password string, name string) *DB {
-	dsn := compileDSN(host, port, username, password, name)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+func NewDBFromConfig(c config.DatabaseConfig) (*DB, error) {
+	b, err := NewBackendFromConfig(c)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	db.AutoMigrate(&Quantity{}, &Data{})
-	return &DB{DB: db}
+	db, err := NewDB(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.migrate(c.AutoMigrate); err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+	if err := b.Configure(db.DB); err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+	return db, nil
 }
 
-func compileDSN(host string, port uint, username string, password string, name string) string {
-	dsnKeyValues := map[string]string{
-		"host":     host,
-		"port":     strconv.Itoa(int(port)),
-		"user":     username,
-		"password": password,
-		"dbname":   name,
-		"sslmode":  "disable",
+// NewDB opens the database without touching the schema. Use NewDBFromConfig to make sure the schema is up to date.
+func NewDB(b Backend) (*DB, error) {
+	// errors are returned to the caller, such that they are logged like all others
+	db, err := gorm.Open(b.Dialector(), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, err
 	}
-	dsnPairs := make([]string, 0, len(dsnKeyValues))
-	for key, value := range dsnKeyValues {
-		dsnPairs = append(dsnPairs, fmt.Sprintf("%s=%s", key, value))
+	return &DB{DB: db, b: b}, nil
+}
+
+func (db *DB) Close() error {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+type Device struct {
+	ID    uint
+	Label string `gorm:"unique; not null"`
+}
+
+// DeviceID returns the ID of the device with the label and creates it if it is not stored yet.
+func (db *DB) DeviceID(label string) (uint, error) {
+	d := Device{Label: label}
+	if err := db.Where(Device{Label: label}).FirstOrCreate(&d).Error; err != nil {
+		return 0, err
 	}
-	return strings.Join(dsnPairs, " ")
+	return d.ID, nil
 }
 
 type Quantity struct {
@@ -47,9 +77,277 @@ type Quantity struct {
 	Datas []Data
 }
 
+// QuantityIDs returns the IDs of the quantities and creates the ones that are not stored yet. The IDs are looked up by
+// name, since quantities defined by the mapping are not registered in a fixed order.
+func (db *DB) QuantityIDs(sqs []summary.Quantity) (map[summary.Quantity]uint, error) {
+	qs := []Quantity{}
+	if err := db.Find(&qs).Error; err != nil {
+		return nil, err
+	}
+	ids := make(map[string]uint, len(qs))
+	for _, q := range qs {
+		ids[q.Name] = q.ID
+	}
+
+	qids := make(map[summary.Quantity]uint, len(sqs))
+	for _, sq := range sqs {
+		id, ok := ids[sq.Name()]
+		if !ok {
+			q := Quantity{Name: sq.Name(), Unit: sq.Unit()}
+			if err := db.Create(&q).Error; err != nil {
+				return nil, err
+			}
+			id = q.ID
+		}
+		qids[sq] = id
+	}
+	return qids, nil
+}
+
 type Data struct {
 	ID         uint
 	Timestamp  time.Time `gorm:"type:timestamptz(0); not null"`
 	QuantityID uint      `gorm:"not null"`
-	Value      float32   `gorm:"type:real; not null"`
+	// Data stored before multiple devices were supported belongs to the first device.
+	DeviceID uint    `gorm:"not null; default:1"`
+	Value    float32 `gorm:"type:real; not null"`
+}
+
+// BeforeCreate stores the timestamp in UTC, since SQLite compares timestamps as text.
+func (d *Data) BeforeCreate(tx *gorm.DB) error {
+	d.Timestamp = d.Timestamp.UTC()
+	return nil
+}
+
+type RawCode struct {
+	ID   uint
+	Code string `gorm:"unique; not null"`
+	Unit string `gorm:"not null"`
+}
+
+type RawData struct {
+	ID        uint
+	Timestamp time.Time `gorm:"type:timestamptz(0); not null"`
+	RawCodeID uint      `gorm:"not null"`
+	DeviceID  uint      `gorm:"not null"`
+	Value     float64   `gorm:"not null"`
+}
+
+func (RawData) TableName() string {
+	return "raw_data"
+}
+
+func (rd *RawData) BeforeCreate(tx *gorm.DB) error {
+	rd.Timestamp = rd.Timestamp.UTC()
+	return nil
+}
+
+// RawCodeID returns the ID of the I18N code and creates it if it is not stored yet.
+func (db *DB) RawCodeID(code string, unit string) (uint, error) {
+	rc := RawCode{Code: code, Unit: unit}
+	if err := db.Where(RawCode{Code: code}).FirstOrCreate(&rc).Error; err != nil {
+		return 0, err
+	}
+	return rc.ID, nil
+}
+
+type DailyEnergy struct {
+	Date     time.Time `gorm:"type:date; primaryKey"`
+	DeviceID uint      `gorm:"primaryKey"`
+	Counter  string    `gorm:"primaryKey"`
+	Value    float64   `gorm:"not null"`
+}
+
+func (DailyEnergy) TableName() string {
+	return "energy_daily"
+}
+
+type MonthlyEnergy struct {
+	Month    time.Time `gorm:"type:date; primaryKey"`
+	DeviceID uint      `gorm:"primaryKey"`
+	Counter  string    `gorm:"primaryKey"`
+	Value    float64   `gorm:"not null"`
+}
+
+func (MonthlyEnergy) TableName() string {
+	return "energy_monthly"
+}
+
+// day returns the local date of t as midnight UTC, such that it is not shifted when stored in a date column.
+func day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func month(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// AddEnergy adds the energy to the totals of the device for the day and month of t.
+func (db *DB) AddEnergy(deviceID uint, t time.Time, ev summary.EnergyValues) error {
+	des := make([]*DailyEnergy, 0, len(ev))
+	mes := make([]*MonthlyEnergy, 0, len(ev))
+	for c, v := range ev {
+		des = append(des, &DailyEnergy{Date: day(t), DeviceID: deviceID, Counter: c.Name(), Value: v})
+		mes = append(mes, &MonthlyEnergy{Month: month(t), DeviceID: deviceID, Counter: c.Name(), Value: v})
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "date"}, {Name: "device_id"}, {Name: "counter"}},
+			DoUpdates: clause.Assignments(map[string]any{"value": gorm.Expr("energy_daily.value + excluded.value")}),
+		}).Create(des).Error; err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "month"}, {Name: "device_id"}, {Name: "counter"}},
+			DoUpdates: clause.Assignments(map[string]any{"value": gorm.Expr("energy_monthly.value + excluded.value")}),
+		}).Create(mes).Error
+	})
+}
+
+// DailyEnergy returns the energy totals of the device for the day of t.
+func (db *DB) DailyEnergy(device string, t time.Time) (summary.EnergyValues, error) {
+	des := []DailyEnergy{}
+	if err := db.Joins("JOIN devices ON devices.id = energy_daily.device_id").
+		Where("devices.label = ? AND energy_daily.date = ?", device, day(t)).
+		Find(&des).Error; err != nil {
+		return nil, err
+	}
+
+	ev := summary.EnergyValues{}
+	for _, de := range des {
+		if c, ok := summary.CounterByName(de.Counter); ok {
+			ev[c] = de.Value
+		}
+	}
+	return ev, nil
+}
+
+// MonthlyEnergy returns the energy totals of the device for the month of t.
+func (db *DB) MonthlyEnergy(device string, t time.Time) (summary.EnergyValues, error) {
+	mes := []MonthlyEnergy{}
+	if err := db.Joins("JOIN devices ON devices.id = energy_monthly.device_id").
+		Where("devices.label = ? AND energy_monthly.month = ?", device, month(t)).
+		Find(&mes).Error; err != nil {
+		return nil, err
+	}
+
+	ev := summary.EnergyValues{}
+	for _, me := range mes {
+		if c, ok := summary.CounterByName(me.Counter); ok {
+			ev[c] = me.Value
+		}
+	}
+	return ev, nil
+}
+
+type Point struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float32   `json:"value"`
+}
+
+// Series returns the stored values of a quantity of the device in the half-open interval [from, to). For a positive
+// step, the stored values are averaged over buckets of that size without weighting them by how long they held. Data
+// that was deleted by the retention is read from the finest rollup that still covers it, weighted by the seconds it
+// covers.
+func (db *DB) Series(device string, name string, from time.Time, to time.Time, step time.Duration) ([]Point, error) {
+	// coarse series are read from an aggregate if available, which has the same columns as the data table
+	table := "data"
+	if ab, ok := db.b.(AggregateBackend); ok && step > 0 {
+		if t, ok := ab.Aggregate(step); ok {
+			table = t
+		}
+	}
+
+	bs, err := db.seriesBuckets(db.seriesQuery(table, device, name, from, to), "data.value", "1", step)
+	if err != nil {
+		return nil, err
+	}
+
+	// rollups only exist before the oldest data, and finer rollups are kept for a shorter time than coarser ones
+	until, err := db.oldest(db.seriesQuery("data", device, name, from, to), to)
+	if err != nil {
+		return nil, err
+	}
+	steps := []uint{}
+	if err := db.Model(&Rollup{}).Distinct("step").Order("step").Pluck("step", &steps).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range steps {
+		// only complete buckets are read, such that they do not overlap the finer ones
+		if until = alignUnix(until, time.Duration(s)*time.Second); !until.After(from) {
+			break
+		}
+		rbs, err := db.seriesBuckets(db.seriesQuery("data_rollups", device, name, from, until).Where("data.step = ?", s), "data.avg", "data.duration", step)
+		if err != nil {
+			return nil, err
+		}
+		bs = append(bs, rbs...)
+		if until, err = db.oldest(db.seriesQuery("data_rollups", device, name, from, until).Where("data.step = ?", s), until); err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(bs, func(a, b seriesBucket) int {
+		return cmp.Compare(a.UnixTime, b.UnixTime)
+	})
+	ps := make([]Point, 0, len(bs))
+	for i := 0; i < len(bs); i++ {
+		b := bs[i]
+		// a bucket might be averaged from both rollups and data
+		for step > 0 && i+1 < len(bs) && bs[i+1].UnixTime == b.UnixTime {
+			i++
+			n := bs[i]
+			b.Value = (b.Value*float64(b.Count) + n.Value*float64(n.Count)) / float64(b.Count+n.Count)
+			b.Count += n.Count
+		}
+		ps = append(ps, Point{Timestamp: time.Unix(int64(b.UnixTime), 0), Value: float32(b.Value)})
+	}
+	return ps, nil
+}
+
+// seriesBucket is a value of a series and the weight it is averaged with.
+type seriesBucket struct {
+	UnixTime float64
+	Value    float64
+	Count    uint64
+}
+
+// seriesQuery selects the rows of a quantity of the device in the half-open interval [from, to) from a table with the
+// columns of the data table.
+func (db *DB) seriesQuery(table string, device string, name string, from time.Time, to time.Time) *gorm.DB {
+	return db.Table(table+" AS data").
+		Joins("JOIN quantities ON quantities.id = data.quantity_id").
+		Joins("JOIN devices ON devices.id = data.device_id").
+		Where("devices.label = ? AND quantities.name = ? AND data.timestamp >= ? AND data.timestamp < ?", device, name, from.UTC(), to.UTC())
+}
+
+// seriesBuckets reads the value column of the query weighted by the count column. For a positive step, the values are
+// averaged over buckets of that size.
+func (db *DB) seriesBuckets(q *gorm.DB, value string, count string, step time.Duration) ([]seriesBucket, error) {
+	if step > 0 {
+		q = q.Select(fmt.Sprintf("? AS unix_time, sum(%[1]s * %[2]s) / sum(%[2]s) AS value, sum(%[2]s) AS count", value, count), db.b.TimeBucket("data.timestamp", step)).
+			Group("unix_time")
+	} else {
+		q = q.Select(fmt.Sprintf("%s AS unix_time, %s AS value, %s AS count", db.b.UnixTime("data.timestamp"), value, count))
+	}
+
+	bs := []seriesBucket{}
+	err := q.Scan(&bs).Error
+	return bs, err
+}
+
+// oldest returns the oldest timestamp selected by the query or the fallback if it selects nothing.
+func (db *DB) oldest(q *gorm.DB, fallback time.Time) (time.Time, error) {
+	var oldest sql.NullFloat64
+	if err := q.Select(db.b.UnixTime("min(data.timestamp)")).Scan(&oldest).Error; err != nil {
+		return time.Time{}, err
+	}
+	if !oldest.Valid {
+		return fallback, nil
+	}
+	return time.Unix(int64(oldest.Float64), 0), nil
 }
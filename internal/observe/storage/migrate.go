@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a versioned change of the schema. The SQL is embedded from
+// migrations/<dir>/<version>_<name>.{up,down}.sql for the directories of the backend.
+type Migration struct {
+	Version uint
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus is a migration and when it was applied. AppliedAt is zero for pending migrations.
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+}
+
+type schemaVersion struct {
+	Version   uint `gorm:"primaryKey; autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaVersion) TableName() string {
+	return "schema_version"
+}
+
+func loadMigrations(dirs []string) ([]Migration, error) {
+	ms := map[uint]*Migration{}
+	for _, dir := range dirs {
+		if err := loadMigrationDir(path.Join("migrations", dir), ms); err != nil {
+			return nil, err
+		}
+	}
+
+	sorted := make([]Migration, 0, len(ms))
+	for _, m := range ms {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		sorted = append(sorted, *m)
+	}
+	slices.SortFunc(sorted, func(a, b Migration) int {
+		return int(a.Version) - int(b.Version)
+	})
+	return sorted, nil
+}
+
+func loadMigrationDir(dir string, ms map[uint]*Migration) error {
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		match := migrationFileRe.FindStringSubmatch(e.Name())
+		if match == nil {
+			return fmt.Errorf("invalid migration file name %s", e.Name())
+		}
+		v, err := strconv.ParseUint(match[1], 10, 0)
+		if err != nil {
+			return err
+		}
+		version := uint(v)
+
+		data, err := fs.ReadFile(migrationsFS, path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+
+		m, ok := ms[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			ms[version] = m
+		} else if m.Name != match[2] {
+			return fmt.Errorf("migrations %s and %s have the same version", m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+	return nil
+}
+
+// MigrationStatus returns all migrations of the backend in order and when they were applied.
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	ms, err := loadMigrations(db.b.Migrations())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version integer PRIMARY KEY, name text NOT NULL, applied_at timestamp NOT NULL)").Error; err != nil {
+		return nil, err
+	}
+	svs := []schemaVersion{}
+	if err := db.Find(&svs).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[uint]time.Time, len(svs))
+	for _, sv := range svs {
+		applied[sv.Version] = sv.AppliedAt
+	}
+
+	mss := make([]MigrationStatus, len(ms))
+	for i, m := range ms {
+		mss[i] = MigrationStatus{Migration: m, AppliedAt: applied[m.Version]}
+	}
+	return mss, nil
+}
+
+// PendingMigrations returns the migrations that are not applied yet.
+func (db *DB) PendingMigrations() ([]Migration, error) {
+	mss, err := db.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	ms := []Migration{}
+	for _, s := range mss {
+		if s.AppliedAt.IsZero() {
+			ms = append(ms, s.Migration)
+		}
+	}
+	return ms, nil
+}
+
+// MigrateUp applies the pending migrations up to and including the version in order. A version of 0 applies all of
+// them. Each migration is applied in its own transaction.
+func (db *DB) MigrateUp(version uint) ([]Migration, error) {
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, m := range pending {
+		if version > 0 && m.Version > version {
+			break
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(m.up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&schemaVersion{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+		}); err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// migrate applies the pending migrations if enabled and otherwise fails if there are any, since the schema would not
+// match.
+func (db *DB) migrate(enabled bool) error {
+	if enabled {
+		_, err := db.MigrateUp(0)
+		return err
+	}
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema has %d pending migrations, run 'telescope migrate up'", len(pending))
+	}
+	return nil
+}
+
+// MigrateDown reverts the given number of applied migrations, starting with the latest one.
+func (db *DB) MigrateDown(steps uint) ([]Migration, error) {
+	mss, err := db.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := []Migration{}
+	for i := len(mss) - 1; i >= 0 && uint(len(reverted)) < steps; i-- {
+		m := mss[i].Migration
+		if mss[i].AppliedAt.IsZero() {
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(m.down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&schemaVersion{Version: m.Version}).Error
+		}); err != nil {
+			return reverted, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
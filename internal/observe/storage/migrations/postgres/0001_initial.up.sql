@@ -0,0 +1,15 @@
+-- The tables might already exist if they were created by a version without migrations.
+CREATE TABLE IF NOT EXISTS quantities (
+	id bigserial PRIMARY KEY,
+	name text NOT NULL,
+	unit text NOT NULL,
+	CONSTRAINT uni_quantities_name UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS data (
+	id bigserial PRIMARY KEY,
+	timestamp timestamptz(0) NOT NULL,
+	quantity_id bigint NOT NULL,
+	value real NOT NULL,
+	CONSTRAINT fk_quantities_datas FOREIGN KEY (quantity_id) REFERENCES quantities (id)
+);
@@ -0,0 +1,42 @@
+-- The tables and columns might already exist if they were created by a version without migrations.
+CREATE TABLE IF NOT EXISTS devices (
+	id bigserial PRIMARY KEY,
+	label text NOT NULL,
+	CONSTRAINT uni_devices_label UNIQUE (label)
+);
+
+-- Data stored before multiple devices were supported belongs to the first device.
+ALTER TABLE data ADD COLUMN IF NOT EXISTS device_id bigint NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_data_device_quantity_timestamp ON data (device_id, quantity_id, timestamp);
+
+CREATE TABLE IF NOT EXISTS energy_daily (
+	date date,
+	device_id bigint,
+	counter text,
+	value decimal NOT NULL,
+	PRIMARY KEY (date, device_id, counter)
+);
+
+CREATE TABLE IF NOT EXISTS energy_monthly (
+	month date,
+	device_id bigint,
+	counter text,
+	value decimal NOT NULL,
+	PRIMARY KEY (month, device_id, counter)
+);
+
+CREATE TABLE IF NOT EXISTS raw_codes (
+	id bigserial PRIMARY KEY,
+	code text NOT NULL,
+	unit text NOT NULL,
+	CONSTRAINT uni_raw_codes_code UNIQUE (code)
+);
+
+CREATE TABLE IF NOT EXISTS raw_data (
+	id bigserial PRIMARY KEY,
+	timestamp timestamptz(0) NOT NULL,
+	raw_code_id bigint NOT NULL,
+	device_id bigint NOT NULL,
+	value decimal NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_raw_data_device_code_timestamp ON raw_data (device_id, raw_code_id, timestamp);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_data_timestamp;
+DROP TABLE data_rollups;
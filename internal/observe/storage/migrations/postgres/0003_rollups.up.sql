@@ -0,0 +1,15 @@
+-- The step of a rollup is its bucket size in seconds.
+CREATE TABLE data_rollups (
+	step integer,
+	timestamp timestamptz(0),
+	device_id bigint,
+	quantity_id bigint,
+	avg real NOT NULL,
+	min real NOT NULL,
+	max real NOT NULL,
+	count bigint NOT NULL,
+	PRIMARY KEY (step, device_id, quantity_id, timestamp)
+);
+
+-- The retention job selects the data by time only.
+CREATE INDEX IF NOT EXISTS idx_data_timestamp ON data (timestamp);
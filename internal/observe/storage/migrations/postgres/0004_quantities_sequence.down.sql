@@ -0,0 +1,2 @@
+-- The sequence is not reset, since it must stay ahead of the stored IDs.
+SELECT 1;
@@ -0,0 +1,3 @@
+-- Versions before the quantity mapping was configurable saved the quantities with explicit IDs, which left the sequence
+-- behind and made inserting new quantities fail with duplicate keys.
+SELECT setval(pg_get_serial_sequence('quantities', 'id'), COALESCE(max(id), 0) + 1, false) FROM quantities;
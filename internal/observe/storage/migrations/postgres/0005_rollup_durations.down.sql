@@ -0,0 +1,2 @@
+ALTER TABLE data_rollups DROP COLUMN last;
+ALTER TABLE data_rollups RENAME COLUMN duration TO count;
@@ -0,0 +1,6 @@
+-- Rollups are weighted by the seconds their value held within the bucket instead of the number of rows, and keep the
+-- value at the end of the bucket, such that the next bucket starts from it. Existing rollups are assumed to cover their
+-- whole bucket with their average.
+ALTER TABLE data_rollups RENAME COLUMN count TO duration;
+ALTER TABLE data_rollups ADD COLUMN last real;
+UPDATE data_rollups SET duration = step, last = avg;
@@ -0,0 +1,15 @@
+-- The tables might already exist if they were created by a version without migrations. Since SQLite was only supported
+-- after devices were introduced, such data tables already have a device column.
+CREATE TABLE IF NOT EXISTS quantities (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	name text NOT NULL UNIQUE,
+	unit text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS data (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	timestamp datetime NOT NULL,
+	quantity_id integer NOT NULL REFERENCES quantities (id),
+	device_id integer NOT NULL DEFAULT 1,
+	value real NOT NULL
+);
@@ -0,0 +1,6 @@
+DROP TABLE raw_data;
+DROP TABLE raw_codes;
+DROP TABLE energy_monthly;
+DROP TABLE energy_daily;
+DROP INDEX idx_data_device_quantity_timestamp;
+DROP TABLE devices;
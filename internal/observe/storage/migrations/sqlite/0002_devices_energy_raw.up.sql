@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS devices (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	label text NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_device_quantity_timestamp ON data (device_id, quantity_id, timestamp);
+
+CREATE TABLE IF NOT EXISTS energy_daily (
+	date date,
+	device_id integer,
+	counter text,
+	value real NOT NULL,
+	PRIMARY KEY (date, device_id, counter)
+);
+
+CREATE TABLE IF NOT EXISTS energy_monthly (
+	month date,
+	device_id integer,
+	counter text,
+	value real NOT NULL,
+	PRIMARY KEY (month, device_id, counter)
+);
+
+CREATE TABLE IF NOT EXISTS raw_codes (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	code text NOT NULL UNIQUE,
+	unit text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS raw_data (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	timestamp datetime NOT NULL,
+	raw_code_id integer NOT NULL,
+	device_id integer NOT NULL,
+	value real NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_raw_data_device_code_timestamp ON raw_data (device_id, raw_code_id, timestamp);
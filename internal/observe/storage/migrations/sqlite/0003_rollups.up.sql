@@ -0,0 +1,15 @@
+-- The step of a rollup is its bucket size in seconds.
+CREATE TABLE IF NOT EXISTS data_rollups (
+	step integer,
+	timestamp datetime,
+	device_id integer,
+	quantity_id integer,
+	avg real NOT NULL,
+	min real NOT NULL,
+	max real NOT NULL,
+	count integer NOT NULL,
+	PRIMARY KEY (step, device_id, quantity_id, timestamp)
+);
+
+-- The retention job selects the data by time only.
+CREATE INDEX IF NOT EXISTS idx_data_timestamp ON data (timestamp);
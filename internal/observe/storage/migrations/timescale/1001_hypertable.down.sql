@@ -0,0 +1,14 @@
+SELECT remove_compression_policy('data', if_exists => true);
+SELECT remove_retention_policy('data', if_exists => true);
+
+-- A hypertable cannot be converted back, so the data is copied to a plain table.
+ALTER SEQUENCE data_id_seq OWNED BY NONE;
+CREATE TABLE data_plain (LIKE data INCLUDING DEFAULTS);
+INSERT INTO data_plain SELECT * FROM data;
+DROP TABLE data;
+ALTER TABLE data_plain RENAME TO data;
+ALTER SEQUENCE data_id_seq OWNED BY data.id;
+
+ALTER TABLE data ADD CONSTRAINT data_pkey PRIMARY KEY (id);
+ALTER TABLE data ADD CONSTRAINT fk_quantities_datas FOREIGN KEY (quantity_id) REFERENCES quantities (id);
+CREATE INDEX idx_data_device_quantity_timestamp ON data (device_id, quantity_id, timestamp);
@@ -0,0 +1,14 @@
+-- The versions of the TimescaleDB migrations start at 1000, such that they do not collide with the ones of Postgres.
+CREATE EXTENSION IF NOT EXISTS timescaledb;
+
+-- All unique indexes of a hypertable have to include the time column.
+ALTER TABLE data DROP CONSTRAINT data_pkey;
+ALTER TABLE data ADD PRIMARY KEY (id, timestamp);
+
+SELECT create_hypertable('data', 'timestamp', chunk_time_interval => INTERVAL '7 days', migrate_data => true);
+
+ALTER TABLE data SET (
+	timescaledb.compress,
+	timescaledb.compress_segmentby = 'device_id, quantity_id',
+	timescaledb.compress_orderby = 'timestamp'
+);
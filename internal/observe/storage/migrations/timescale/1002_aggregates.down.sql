@@ -0,0 +1,3 @@
+DROP MATERIALIZED VIEW data_1d;
+DROP MATERIALIZED VIEW data_1h;
+DROP MATERIALIZED VIEW data_1m;
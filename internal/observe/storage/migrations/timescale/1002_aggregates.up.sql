@@ -0,0 +1,57 @@
+-- The aggregates are refreshed by their policies, which only cover recent data. Data stored before the migration is
+-- aggregated when the database is opened.
+--
+-- Since only changes of the values are stored, the value of a bucket is the mean of the changes that fall into it and
+-- not the average over time: a value that held for an hour counts as much as one that held for a second, and buckets
+-- without any change are missing. It is meant for plotting, not for computing energies or other totals. A time-weighted
+-- average would need the value before each bucket, which continuous aggregates cannot carry over.
+CREATE MATERIALIZED VIEW data_1m WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket(INTERVAL '1 minute', timestamp) AS timestamp,
+	device_id,
+	quantity_id,
+	avg(value) AS value,
+	min(value) AS min,
+	max(value) AS max
+FROM data
+GROUP BY time_bucket(INTERVAL '1 minute', timestamp), device_id, quantity_id
+WITH NO DATA;
+SELECT add_continuous_aggregate_policy('data_1m',
+	start_offset => INTERVAL '1 hour',
+	end_offset => INTERVAL '1 minute',
+	schedule_interval => INTERVAL '1 minute'
+);
+
+CREATE MATERIALIZED VIEW data_1h WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket(INTERVAL '1 hour', timestamp) AS timestamp,
+	device_id,
+	quantity_id,
+	avg(value) AS value,
+	min(value) AS min,
+	max(value) AS max
+FROM data
+GROUP BY time_bucket(INTERVAL '1 hour', timestamp), device_id, quantity_id
+WITH NO DATA;
+SELECT add_continuous_aggregate_policy('data_1h',
+	start_offset => INTERVAL '1 day',
+	end_offset => INTERVAL '1 hour',
+	schedule_interval => INTERVAL '1 hour'
+);
+
+CREATE MATERIALIZED VIEW data_1d WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket(INTERVAL '1 day', timestamp) AS timestamp,
+	device_id,
+	quantity_id,
+	avg(value) AS value,
+	min(value) AS min,
+	max(value) AS max
+FROM data
+GROUP BY time_bucket(INTERVAL '1 day', timestamp), device_id, quantity_id
+WITH NO DATA;
+SELECT add_continuous_aggregate_policy('data_1d',
+	start_offset => INTERVAL '7 days',
+	end_offset => INTERVAL '1 day',
+	schedule_interval => INTERVAL '1 day'
+);
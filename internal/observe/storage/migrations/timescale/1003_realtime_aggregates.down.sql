@@ -0,0 +1,3 @@
+ALTER MATERIALIZED VIEW data_1d SET (timescaledb.materialized_only = true);
+ALTER MATERIALIZED VIEW data_1h SET (timescaledb.materialized_only = true);
+ALTER MATERIALIZED VIEW data_1m SET (timescaledb.materialized_only = true);
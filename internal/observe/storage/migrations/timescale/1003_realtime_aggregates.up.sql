@@ -0,0 +1,4 @@
+-- The aggregates include the data that is not materialized yet, such that series are complete up to now.
+ALTER MATERIALIZED VIEW data_1m SET (timescaledb.materialized_only = false);
+ALTER MATERIALIZED VIEW data_1h SET (timescaledb.materialized_only = false);
+ALTER MATERIALIZED VIEW data_1d SET (timescaledb.materialized_only = false);
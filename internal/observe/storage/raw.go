@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/pmeier/telescope/internal/summary"
+)
+
+type timestampedRawValue struct {
+	T time.Time
+	V summary.RawValue
+}
+
+// rawValue is a raw value to store. The code is resolved to its ID when it is written.
+type rawValue struct {
+	Timestamp time.Time
+	Code      string
+	Unit      string
+	Value     float64
+}
+
+// rawStore stores the raw values of a summary whenever they change. Like for the quantities, the held value is stored
+// again right before the change such that the recorded data keeps its steps.
+type rawStore struct {
+	tvs      map[string]timestampedRawValue
+	lastTick time.Time
+}
+
+func newRawStore() *rawStore {
+	return &rawStore{tvs: map[string]timestampedRawValue{}}
+}
+
+// store returns the values to store for the raw values.
+func (rs *rawStore) store(t time.Time, raw summary.RawValues) []*rawValue {
+	rvs := []*rawValue{}
+	for code, rv := range raw {
+		tv, ok := rs.tvs[code]
+		if ok && tv.V.Value == rv.Value {
+			continue
+		}
+
+		if ok && tv.T.Before(rs.lastTick) {
+			rvs = append(rvs, &rawValue{Timestamp: rs.lastTick, Code: code, Unit: tv.V.Unit, Value: tv.V.Value})
+		}
+		rvs = append(rvs, &rawValue{Timestamp: t, Code: code, Unit: rv.Unit, Value: rv.Value})
+		rs.tvs[code] = timestampedRawValue{T: t, V: rv}
+	}
+	rs.lastTick = t
+	return rvs
+}
+
+// flush returns the values for the held values that have not changed since they were last stored.
+func (rs *rawStore) flush() []*rawValue {
+	rvs := []*rawValue{}
+	for code, tv := range rs.tvs {
+		if tv.T.Before(rs.lastTick) {
+			rvs = append(rvs, &rawValue{Timestamp: rs.lastTick, Code: code, Unit: tv.V.Unit, Value: tv.V.Value})
+		}
+	}
+	return rvs
+}
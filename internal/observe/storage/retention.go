@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rollupWindow is the number of buckets that are rolled up at once, which bounds the memory used by the job.
+const rollupWindow = 1000
+
+// Rollup is the data of a quantity of a device aggregated over a bucket. The step is the size of the bucket in seconds.
+// Duration is how many seconds of the bucket are covered by values, and Last is the value at its end.
+type Rollup struct {
+	Step       uint      `gorm:"primaryKey"`
+	Timestamp  time.Time `gorm:"primaryKey"`
+	DeviceID   uint      `gorm:"primaryKey"`
+	QuantityID uint      `gorm:"primaryKey"`
+	Avg        float32
+	Min        float32
+	Max        float32
+	Duration   uint
+	Last       float32
+}
+
+func (Rollup) TableName() string {
+	return "data_rollups"
+}
+
+func (r *Rollup) BeforeCreate(tx *gorm.DB) error {
+	r.Timestamp = r.Timestamp.UTC()
+	return nil
+}
+
+// RollupReport holds the number of buckets that were rolled up and the number of rollups that were deleted.
+type RollupReport struct {
+	Step     time.Duration
+	RolledUp int
+	Deleted  int64
+}
+
+// RetentionReport holds what the retention did. For a dry run, it holds what it would have done. Before is zero if no
+// data is deleted.
+type RetentionReport struct {
+	DryRun  bool
+	Before  time.Time
+	Deleted int64
+	Rollups []RollupReport
+}
+
+// ApplyRetention rolls up the data older than the retention and deletes it afterwards. The data is only deleted up to
+// the last complete bucket of all rollups and in the same transaction as it is rolled up, such that all data that is
+// still stored is not rolled up yet. Rollups are deleted after their own retention. It is not supported for the
+// TimescaleBackend, since deleting data would also remove it from the aggregates.
+func (db *DB) ApplyRetention(ctx context.Context, c config.RetentionConfig, now time.Time, dryRun bool) (RetentionReport, error) {
+	if _, ok := db.b.(*TimescaleBackend); ok {
+		return RetentionReport{}, errors.New("retention is applied by timescale, configure its retention instead")
+	}
+
+	r := RetentionReport{DryRun: dryRun}
+
+	var before time.Time
+	if c.Data > 0 {
+		before = now.Add(-c.Data)
+		for _, rc := range c.Rollups {
+			if end := alignUnix(before, rc.Step); end.Before(before) {
+				before = end
+			}
+		}
+	}
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		db := &DB{DB: tx, b: db.b}
+		for _, rc := range c.Rollups {
+			rr := RollupReport{Step: rc.Step}
+
+			if !before.IsZero() {
+				n, err := db.rollup(rc.Step, before, dryRun)
+				if err != nil {
+					return err
+				}
+				rr.RolledUp = n
+			}
+
+			if rc.Retention > 0 {
+				q := db.Where("step = ? AND timestamp < ?", uint(rc.Step.Seconds()), now.Add(-rc.Retention).UTC())
+				n, err := deleteOrCount(q, &Rollup{}, dryRun)
+				if err != nil {
+					return err
+				}
+				rr.Deleted = n
+			}
+
+			r.Rollups = append(r.Rollups, rr)
+		}
+
+		if !before.IsZero() {
+			n, err := deleteOrCount(db.Where("timestamp < ?", before.UTC()), &Data{}, dryRun)
+			if err != nil {
+				return err
+			}
+			r.Before = before
+			r.Deleted = n
+		}
+		return nil
+	})
+	if err != nil {
+		return RetentionReport{DryRun: dryRun}, err
+	}
+	return r, nil
+}
+
+// rollup aggregates the data before end into buckets of the step and returns their number. Since only changes of the
+// values are stored, the data is a step function: each value holds until the next one, and the value at the end of a
+// bucket is carried into the following ones. The aggregates are therefore weighted by how long each value held, and
+// buckets without any change still get a rollup. Rows that were stored late, e.g. when spilled writes are replayed,
+// fall into buckets that are already rolled up and are merged into them.
+func (db *DB) rollup(step time.Duration, end time.Time, dryRun bool) (int, error) {
+	s := int64(step.Seconds())
+
+	// each series continues after its latest rollup with the value at its end
+	ls := []struct {
+		DeviceID   uint
+		QuantityID uint
+		UnixTime   float64
+		Last       float32
+	}{}
+	latest := db.Model(&Rollup{}).Select("device_id, quantity_id, max(timestamp) AS timestamp").Where("step = ?", s).Group("device_id, quantity_id")
+	if err := db.Table("data_rollups AS r").
+		Select("r.device_id, r.quantity_id, "+db.b.UnixTime("r.timestamp")+" AS unix_time, r.last").
+		Joins("JOIN (?) AS l ON l.device_id = r.device_id AND l.quantity_id = r.quantity_id AND l.timestamp = r.timestamp", latest).
+		Where("r.step = ?", s).
+		Scan(&ls).Error; err != nil {
+		return 0, err
+	}
+	states := map[rollupSeries]*rollupState{}
+	start := end.Unix()
+	for _, l := range ls {
+		next := int64(l.UnixTime) + s
+		states[rollupSeries{l.DeviceID, l.QuantityID}] = &rollupState{next: next, value: l.Last, valid: true}
+		start = min(start, next)
+	}
+
+	var oldest sql.NullFloat64
+	if err := db.Model(&Data{}).Select(db.b.UnixTime("min(timestamp)")).Where("timestamp < ?", end.UTC()).Scan(&oldest).Error; err != nil {
+		return 0, err
+	}
+	if oldest.Valid {
+		start = min(start, int64(oldest.Float64)/s*s)
+	}
+
+	n := 0
+	for from := start; from < end.Unix(); from += s * rollupWindow {
+		to := min(from+s*rollupWindow, end.Unix())
+
+		rows := []rollupRow{}
+		if err := db.Model(&Data{}).
+			Select(db.b.UnixTime("timestamp")+" AS unix_time, device_id, quantity_id, value").
+			Where("timestamp >= ? AND timestamp < ?", time.Unix(from, 0).UTC(), time.Unix(to, 0).UTC()).
+			Order("device_id, quantity_id, timestamp").
+			Scan(&rows).Error; err != nil {
+			return n, err
+		}
+		series := map[rollupSeries][]rollupRow{}
+		for _, r := range rows {
+			k := rollupSeries{r.DeviceID, r.QuantityID}
+			series[k] = append(series[k], r)
+			if _, ok := states[k]; !ok {
+				states[k] = &rollupState{next: int64(r.UnixTime) / s * s}
+			}
+		}
+
+		var rs, late []*Rollup
+		for k, st := range states {
+			lrs, brs := st.advance(k, series[k], s, to)
+			late = append(late, lrs...)
+			rs = append(rs, brs...)
+		}
+		n += len(rs) + len(late)
+		if dryRun {
+			continue
+		}
+
+		if len(rs) > 0 {
+			if err := db.CreateInBatches(rs, 500).Error; err != nil {
+				return n, err
+			}
+		}
+		if len(late) > 0 {
+			if err := db.Clauses(mergeRollups).CreateInBatches(late, 500).Error; err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+type rollupSeries struct {
+	DeviceID   uint
+	QuantityID uint
+}
+
+type rollupRow struct {
+	UnixTime   float64
+	DeviceID   uint
+	QuantityID uint
+	Value      float32
+}
+
+// rollupState is where the rollup of a series continues: the start of its next bucket and the value at that time. The
+// value is not valid before the first row of a series.
+type rollupState struct {
+	next  int64
+	value float32
+	valid bool
+}
+
+// advance rolls up the buckets of the series up to end from the rows in between, which are sorted by time. Rows before
+// the next bucket are late and returned as separate rollups of their buckets, weighted by how long they held until
+// the following row or the end of their bucket.
+func (st *rollupState) advance(k rollupSeries, rows []rollupRow, s int64, end int64) (late []*Rollup, rs []*Rollup) {
+	i := 0
+	for ; i < len(rows) && rows[i].UnixTime < float64(st.next); i++ {
+		r := rows[i]
+		b := int64(r.UnixTime) / s * s
+		until := float64(min(b+s, st.next))
+		if i+1 < len(rows) {
+			until = min(until, rows[i+1].UnixTime)
+		}
+		// rows held for less than a second do not change the rollup and would weight it by 0
+		if d := math.Round(until - r.UnixTime); d > 0 {
+			late = append(late, &Rollup{
+				Step: uint(s), Timestamp: time.Unix(b, 0), DeviceID: k.DeviceID, QuantityID: k.QuantityID,
+				Avg: r.Value, Min: r.Value, Max: r.Value, Duration: uint(d), Last: r.Value,
+			})
+		}
+		// the latest replayed value is the one that holds at the start of the next bucket
+		st.value, st.valid = r.Value, true
+	}
+
+	for ; st.next < end; st.next += s {
+		r := &Rollup{Step: uint(s), Timestamp: time.Unix(st.next, 0), DeviceID: k.DeviceID, QuantityID: k.QuantityID}
+		t, bucketEnd := float64(st.next), float64(st.next+s)
+		var sum, d float64
+		held := false
+		hold := func(until float64) {
+			if !st.valid {
+				return
+			}
+			if !held || st.value < r.Min {
+				r.Min = st.value
+			}
+			if !held || st.value > r.Max {
+				r.Max = st.value
+			}
+			held = true
+			sum += float64(st.value) * (until - t)
+			d += until - t
+		}
+		for ; i < len(rows) && rows[i].UnixTime < bucketEnd; i++ {
+			hold(rows[i].UnixTime)
+			t = rows[i].UnixTime
+			st.value, st.valid = rows[i].Value, true
+		}
+		hold(bucketEnd)
+		if !held {
+			continue
+		}
+
+		r.Avg = float32(sum / d)
+		r.Duration = max(1, uint(math.Round(d)))
+		r.Last = st.value
+		rs = append(rs, r)
+	}
+	return late, rs
+}
+
+// mergeRollups combines a new rollup with the existing one of the same bucket, weighted by their durations.
+var mergeRollups = clause.OnConflict{
+	Columns: []clause.Column{{Name: "step"}, {Name: "device_id"}, {Name: "quantity_id"}, {Name: "timestamp"}},
+	DoUpdates: clause.Assignments(map[string]any{
+		"avg":      gorm.Expr("(data_rollups.avg * data_rollups.duration + excluded.avg * excluded.duration) / (data_rollups.duration + excluded.duration)"),
+		"min":      gorm.Expr("CASE WHEN excluded.min < data_rollups.min THEN excluded.min ELSE data_rollups.min END"),
+		"max":      gorm.Expr("CASE WHEN excluded.max > data_rollups.max THEN excluded.max ELSE data_rollups.max END"),
+		"duration": gorm.Expr("data_rollups.duration + excluded.duration"),
+	}),
+}
+
+func deleteOrCount(q *gorm.DB, model any, dryRun bool) (int64, error) {
+	if dryRun {
+		var n int64
+		err := q.Model(model).Count(&n).Error
+		return n, err
+	}
+	res := q.Delete(model)
+	return res.RowsAffected, res.Error
+}
+
+// alignUnix returns the start of the bucket of the step t falls into. Like in the database, buckets are aligned to
+// the Unix epoch.
+func alignUnix(t time.Time, step time.Duration) time.Time {
+	s := int64(step.Seconds())
+	return time.Unix(t.Unix()/s*s, 0)
+}
+
+// retentionJob applies the retention periodically until it is closed.
+type retentionJob struct {
+	db     *DB
+	c      config.RetentionConfig
+	log    zerolog.Logger
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startRetentionJob(db *DB, c config.RetentionConfig, log zerolog.Logger) *retentionJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &retentionJob{db: db, c: c, log: log, cancel: cancel, done: make(chan struct{})}
+	go j.run(ctx)
+	return j
+}
+
+func (j *retentionJob) run(ctx context.Context) {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.c.Interval)
+	defer ticker.Stop()
+	for {
+		j.apply(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *retentionJob) apply(ctx context.Context) {
+	r, err := j.db.ApplyRetention(ctx, j.c, time.Now(), j.c.DryRun)
+	if err != nil {
+		if ctx.Err() == nil {
+			j.log.Error().Err(err).Msg("applying retention failed")
+		}
+		return
+	}
+
+	for _, rr := range r.Rollups {
+		j.log.Info().Bool("dry_run", r.DryRun).Stringer("step", rr.Step).Int("rolled_up", rr.RolledUp).Int64("deleted", rr.Deleted).Msg("applied rollup retention")
+	}
+	j.log.Info().Bool("dry_run", r.DryRun).Time("before", r.Before).Int64("deleted", r.Deleted).Msg("applied data retention")
+}
+
+func (j *retentionJob) close() {
+	j.cancel()
+	<-j.done
+}
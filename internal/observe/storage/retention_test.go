@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func storeValues(t *testing.T, db *DB, vs map[int64]float32) {
+	t.Helper()
+	deviceID, err := db.DeviceID("inverter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts, v := range vs {
+		if err := db.Create(&Data{Timestamp: time.Unix(ts, 0), QuantityID: 1, DeviceID: deviceID, Value: v}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func storedRollups(t *testing.T, db *DB) []Rollup {
+	t.Helper()
+	rs := []Rollup{}
+	if err := db.Order("timestamp").Find(&rs).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := range rs {
+		rs[i].Timestamp = rs[i].Timestamp.UTC()
+	}
+	return rs
+}
+
+func testRollup(ts int64, avg, min, max float32, duration uint, last float32) Rollup {
+	return Rollup{Step: 60, Timestamp: time.Unix(ts, 0).UTC(), DeviceID: 1, QuantityID: 1, Avg: avg, Min: min, Max: max, Duration: duration, Last: last}
+}
+
+func TestRollupStepFunction(t *testing.T) {
+	db := newTestDB(t)
+
+	// values hold until the next one, such that steady buckets are rolled up from the value before them
+	storeValues(t, db, map[int64]float32{0: 10, 30: 20, 150: 0})
+	if n, err := db.rollup(time.Minute, time.Unix(240, 0), false); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Errorf("rolled up %d buckets, want 4", n)
+	}
+	want := []Rollup{
+		testRollup(0, 15, 10, 20, 60, 20),
+		testRollup(60, 20, 20, 20, 60, 20),
+		testRollup(120, 10, 0, 20, 60, 0),
+		testRollup(180, 0, 0, 0, 60, 0),
+	}
+	if got := storedRollups(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("rolled up %+v, want %+v", got, want)
+	}
+
+	// once the data is deleted, the next rollup continues from the value at the end of the last bucket
+	if err := db.Where("timestamp < ?", time.Unix(240, 0).UTC()).Delete(&Data{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	storeValues(t, db, map[int64]float32{330: 6})
+	if _, err := db.rollup(time.Minute, time.Unix(360, 0), false); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want,
+		testRollup(240, 0, 0, 0, 60, 0),
+		testRollup(300, 3, 0, 6, 60, 6),
+	)
+	if got := storedRollups(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("rolled up %+v after deleting the data, want %+v", got, want)
+	}
+
+	// late rows are merged into their buckets, weighted by how long they held
+	if err := db.Where("timestamp < ?", time.Unix(360, 0).UTC()).Delete(&Data{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	storeValues(t, db, map[int64]float32{90: 50})
+	if _, err := db.rollup(time.Minute, time.Unix(360, 0), false); err != nil {
+		t.Fatal(err)
+	}
+	want[1] = testRollup(60, 30, 20, 50, 90, 20)
+	if got := storedRollups(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("rolled up %+v after a late row, want %+v", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"math"
 	"time"
 
@@ -19,26 +20,53 @@ type timestampedSummary struct {
 	tvs      map[summary.Quantity]timestampedValue
 }
 
+// deviceState holds the values last stored for a device.
+type deviceState struct {
+	ts  timestampedSummary
+	raw *rawStore
+}
+
 type StorageSummaryHandler struct {
 	Log         zerolog.Logger
 	thresholds  map[summary.Quantity]float64
 	tw          ThresholdWeighter
 	db          *DB
 	quantityIDS map[summary.Quantity]uint
-	ts          timestampedSummary
+	w           *writer
+	retention   *retentionJob
+	raw         bool
+	devices     map[string]*deviceState
+}
+
+// SetDB sets the database the summaries are stored in. The database is owned by the caller.
+func (sh *StorageSummaryHandler) SetDB(db *DB) {
+	sh.db = db
 }
 
 func (sh *StorageSummaryHandler) Setup(c config.ObserveConfig, log zerolog.Logger, s summary.Summary) error {
 	sc := c.Storage
-	db := NewDB(sc.Database.Host, sc.Database.Port, sc.Database.Username, sc.Database.Password, sc.Database.Name)
-	sh.db = db
+	db := sh.db
+	if db == nil {
+		return errors.New("storage requires a database")
+	}
 
 	sh.thresholds = map[summary.Quantity]float64{
-		summary.GridPower:    sc.Thresholds.GridPower,
-		summary.BatteryPower: sc.Thresholds.BatteryPower,
-		summary.PVPower:      sc.Thresholds.PVPower,
-		summary.LoadPower:    sc.Thresholds.LoadPower,
-		summary.BatteryLevel: sc.Thresholds.BatteryLevel,
+		summary.GridPower:             sc.Thresholds.GridPower,
+		summary.BatteryPower:          sc.Thresholds.BatteryPower,
+		summary.PVPower:               sc.Thresholds.PVPower,
+		summary.LoadPower:             sc.Thresholds.LoadPower,
+		summary.BatteryLevel:          sc.Thresholds.BatteryLevel,
+		summary.GridImportPower:       sc.Thresholds.GridImportPower,
+		summary.GridExportPower:       sc.Thresholds.GridExportPower,
+		summary.BatteryChargePower:    sc.Thresholds.BatteryChargePower,
+		summary.BatteryDischargePower: sc.Thresholds.BatteryDischargePower,
+		summary.Autarky:               sc.Thresholds.Autarky,
+		summary.SelfConsumptionRate:   sc.Thresholds.SelfConsumptionRate,
+	}
+	for name, threshold := range sc.Thresholds.Extra {
+		if q, ok := summary.QuantityByName(name); ok {
+			sh.thresholds[q] = threshold
+		}
 	}
 
 	sh.tw = ExponentialCutoffThresholdWeighter{
@@ -46,50 +74,113 @@ func (sh *StorageSummaryHandler) Setup(c config.ObserveConfig, log zerolog.Logge
 		Factor: sc.ThresholdWeighter.Factor,
 	}
 
-	qids := map[summary.Quantity]uint{}
-	qs := []*Quantity{}
-	for i, sq := range summary.Quantities() {
-		id := uint(i) + 1
-		qids[sq] = id
-
-		qs = append(qs, &Quantity{ID: id, Name: sq.Name(), Unit: sq.Unit()})
+	qids, err := db.QuantityIDs(summary.Quantities())
+	if err != nil {
+		return err
 	}
 	sh.quantityIDS = qids
-	db.Save(qs)
 
-	tvs := make(map[summary.Quantity]timestampedValue, len(s.Values))
-	ds := make([]*Data, 0, len(s.Values))
-	for q, v := range s.Values {
-		tvs[q] = timestampedValue{T: s.Timestamp, V: v}
+	sh.raw = sc.Raw.Enabled
+	sh.devices = map[string]*deviceState{}
+	sh.w = newWriter(db, sc.Buffer, log)
 
-		ds = append(ds, &Data{Timestamp: s.Timestamp, QuantityID: qids[q], Value: v})
+	// handlers that failed to set up are not closed
+	if err := sh.Handle(s); err != nil {
+		return errors.Join(err, sh.w.close())
 	}
-	sh.ts = timestampedSummary{lastTick: s.Timestamp, tvs: tvs}
-	db.Create(ds)
 
+	if sc.Retention.Enabled {
+		sh.retention = startRetentionJob(db, sc.Retention, log)
+	}
 	return nil
 }
 
+func (sh *StorageSummaryHandler) device(label string) *deviceState {
+	if ds, ok := sh.devices[label]; ok {
+		return ds
+	}
+
+	ds := &deviceState{}
+	if sh.raw {
+		ds.raw = newRawStore()
+	}
+	sh.devices[label] = ds
+	return ds
+}
+
 func (sh *StorageSummaryHandler) Handle(s summary.Summary) error {
+	dev := sh.device(s.Device)
+	b := &batch{Device: s.Device}
+
+	// the raw values are stored as reported, since they are meant for retrospective analysis
+	if dev.raw != nil {
+		b.Raw = dev.raw.store(s.Timestamp, s.Raw)
+	}
+
+	// samples with violations only reach the storage with the mark action, which only applies to the live outputs.
+	// They are not stored to avoid spikes in the recorded data, but the raw values above are.
+	if len(s.Violations) > 0 {
+		return sh.w.write(b)
+	}
+
 	ds := []*Data{}
-	for q, v := range s.Values {
-		tv := sh.ts.tvs[q]
-		if math.Abs(float64(tv.V-v)) <= sh.thresholds[q]*sh.tw.Weight(s.Timestamp.Sub(tv.T)) {
-			continue
+	if dev.ts.tvs == nil {
+		// all values of the first summary of a device are stored
+		dev.ts.tvs = make(map[summary.Quantity]timestampedValue, len(s.Values))
+		for q, v := range s.Values {
+			dev.ts.tvs[q] = timestampedValue{T: s.Timestamp, V: v}
+			ds = append(ds, &Data{Timestamp: s.Timestamp, QuantityID: sh.quantityIDS[q], Value: v})
+		}
+	} else {
+		for q, v := range s.Values {
+			tv := dev.ts.tvs[q]
+			if math.Abs(float64(tv.V-v)) <= sh.thresholds[q]*sh.tw.Weight(s.Timestamp.Sub(tv.T)) {
+				continue
+			}
+
+			qid := sh.quantityIDS[q]
+			ds = append(ds, &Data{Timestamp: dev.ts.lastTick, QuantityID: qid, Value: tv.V})
+			ds = append(ds, &Data{Timestamp: s.Timestamp, QuantityID: qid, Value: v})
+			dev.ts.tvs[q] = timestampedValue{T: s.Timestamp, V: v}
 		}
+	}
+	dev.ts.lastTick = s.Timestamp
+	b.Data = ds
 
-		qid := sh.quantityIDS[q]
-		ds = append(ds, &Data{Timestamp: sh.ts.lastTick, QuantityID: qid, Value: tv.V})
-		ds = append(ds, &Data{Timestamp: s.Timestamp, QuantityID: qid, Value: v})
-		sh.ts.tvs[q] = timestampedValue{T: s.Timestamp, V: v}
+	if len(s.Energy) > 0 {
+		b.Energy = newEnergyDelta(s.Timestamp, s.Energy)
 	}
-	sh.ts.lastTick = s.Timestamp
 
-	if len(ds) > 0 {
-		sh.db.Create(ds)
+	return sh.w.write(b)
+}
+
+// Lossless marks the handler as one that must not miss summaries, since the energy totals are accumulated from the
+// deltas of consecutive summaries.
+func (sh *StorageSummaryHandler) Lossless() {}
+
+// Close stores the held values of all quantities that have not changed since they were last stored, such that the
+// recorded data extends up to the last observation.
+func (sh *StorageSummaryHandler) Close() error {
+	errs := []error{}
+	for label, dev := range sh.devices {
+		b := &batch{Device: label}
+		for q, tv := range dev.ts.tvs {
+			if tv.T.Before(dev.ts.lastTick) {
+				b.Data = append(b.Data, &Data{Timestamp: dev.ts.lastTick, QuantityID: sh.quantityIDS[q], Value: tv.V})
+			}
+		}
+
+		if dev.raw != nil {
+			b.Raw = dev.raw.flush()
+		}
+		errs = append(errs, sh.w.write(b))
 	}
 
-	return nil
+	if sh.retention != nil {
+		sh.retention.close()
+	}
+
+	return errors.Join(append(errs, sh.w.close())...)
 }
 
 type ThresholdWeighter interface {
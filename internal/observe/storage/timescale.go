@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AggregateBackend is optionally implemented by a Backend that maintains aggregates of the data. They are used to
+// serve series with a coarse step without scanning all data. Like the buckets of the data table, their values are the
+// mean of the stored changes and not weighted by how long each value held.
+type AggregateBackend interface {
+	Backend
+	// Aggregate returns the table of the aggregate with the largest bucket the step is a multiple of. Like the data
+	// table, it has the columns timestamp, device_id, quantity_id, and value.
+	Aggregate(step time.Duration) (string, bool)
+}
+
+// timescaleAggregates are the continuous aggregates created by the migrations ordered by decreasing bucket size.
+var timescaleAggregates = []struct {
+	table  string
+	bucket time.Duration
+}{
+	{"data_1d", time.Hour * 24},
+	{"data_1h", time.Hour},
+	{"data_1m", time.Minute},
+}
+
+// timescaleRefreshWindow is the largest start offset of the refresh policies of the aggregates. Data has to be kept
+// for longer, since refreshing an aggregate over dropped data removes the aggregated values as well.
+const timescaleRefreshWindow = time.Hour * 24 * 7
+
+// TimescaleBackend is a PostgresBackend with the TimescaleDB extension. The data table is a hypertable and aggregated
+// by continuous aggregates. The compression and retention policies are set and the aggregates are refreshed whenever
+// the database is opened.
+type TimescaleBackend struct {
+	PostgresBackend
+	CompressAfter time.Duration
+	Retention     time.Duration
+}
+
+func (b *TimescaleBackend) Migrations() []string {
+	return []string{"postgres", "timescale"}
+}
+
+func (b *TimescaleBackend) Configure(db *gorm.DB) error {
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT remove_compression_policy('data', if_exists => true)").Error; err != nil {
+			return err
+		}
+		if b.CompressAfter > 0 {
+			if err := tx.Exec("SELECT add_compression_policy('data', compress_after => CAST(? AS interval))", interval(b.CompressAfter)).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Exec("SELECT remove_retention_policy('data', if_exists => true)").Error; err != nil {
+			return err
+		}
+		if b.Retention > 0 {
+			if err := tx.Exec("SELECT add_retention_policy('data', drop_after => CAST(? AS interval))", interval(b.Retention)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return b.refresh(db)
+}
+
+// refresh materializes the aggregates for all data the refresh policies do not cover, e.g. data stored before the
+// aggregates were created. Buckets that are already up to date are skipped, such that this is cheap after the first
+// time. Refreshing cannot run in a transaction and must not cover dropped data, since its buckets would be removed.
+func (b *TimescaleBackend) refresh(db *gorm.DB) error {
+	var start *time.Time
+	if b.Retention > 0 {
+		t := time.Now().Add(-b.Retention).UTC()
+		start = &t
+	}
+
+	for _, a := range timescaleAggregates {
+		if err := db.Exec("CALL refresh_continuous_aggregate(?, CAST(? AS timestamptz), NULL)", a.table, start).Error; err != nil {
+			return fmt.Errorf("refreshing %s: %w", a.table, err)
+		}
+	}
+	return nil
+}
+
+func (b *TimescaleBackend) Aggregate(step time.Duration) (string, bool) {
+	for _, a := range timescaleAggregates {
+		if step >= a.bucket && step%a.bucket == 0 {
+			return a.table, true
+		}
+	}
+	return "", false
+}
+
+func interval(d time.Duration) string {
+	return fmt.Sprintf("%d seconds", int64(d.Seconds()))
+}
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/summary"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// batch holds the rows of a single summary of a device. It is written in a single transaction, such that it can be
+// retried without storing rows twice.
+type batch struct {
+	Device string
+	Data   []*Data      `json:",omitempty"`
+	Raw    []*rawValue  `json:",omitempty"`
+	Energy *energyDelta `json:",omitempty"`
+}
+
+// energyDelta is the energy that is added to the totals of the device. The counters are stored by name to keep spill
+// files readable across versions.
+type energyDelta struct {
+	Timestamp time.Time
+	Values    map[string]float64
+}
+
+func newEnergyDelta(t time.Time, ev summary.EnergyValues) *energyDelta {
+	vs := make(map[string]float64, len(ev))
+	for c, v := range ev {
+		vs[c.Name()] = v
+	}
+	return &energyDelta{Timestamp: t, Values: vs}
+}
+
+func (b *batch) empty() bool {
+	return len(b.Data) == 0 && len(b.Raw) == 0 && b.Energy == nil
+}
+
+// writer writes batches to the database on its own goroutine, such that the handler is not blocked while the database
+// is unavailable. Failed writes are retried with exponential backoff.
+type writer struct {
+	db      *DB
+	c       config.BufferConfig
+	log     zerolog.Logger
+	batches chan *batch
+	done    chan struct{}
+	pending []*batch
+	attempt uint
+	// the IDs are only accessed by the goroutine of the writer
+	deviceIDs  map[string]uint
+	rawCodeIDs map[string]uint
+
+	mu  sync.Mutex
+	err error
+}
+
+func newWriter(db *DB, c config.BufferConfig, log zerolog.Logger) *writer {
+	w := &writer{
+		db:         db,
+		c:          c,
+		log:        log,
+		batches:    make(chan *batch, c.Size),
+		done:       make(chan struct{}),
+		deviceIDs:  map[string]uint{},
+		rawCodeIDs: map[string]uint{},
+	}
+	go w.run()
+	return w
+}
+
+// write queues the batch. It returns the error if batches were lost since the last call, because they could neither
+// be written nor spilled.
+func (w *writer) write(b *batch) error {
+	err := w.takeError()
+	if !b.empty() {
+		w.batches <- b
+	}
+	return err
+}
+
+// close writes or spills all pending batches and stops the writer.
+func (w *writer) close() error {
+	close(w.batches)
+	<-w.done
+	return w.takeError()
+}
+
+// takeError returns the errors since it was last called.
+func (w *writer) takeError() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.err
+	w.err = nil
+	return err
+}
+
+func (w *writer) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = errors.Join(w.err, err)
+}
+
+// store writes the batch. The IDs of the device and the raw codes are resolved here rather than by the handler, such
+// that it does not wait for the database.
+func (w *writer) store(b *batch) error {
+	deviceID, err := w.deviceID(b.Device)
+	if err != nil {
+		return err
+	}
+	rds := make([]*RawData, len(b.Raw))
+	for i, rv := range b.Raw {
+		id, err := w.rawCodeID(rv.Code, rv.Unit)
+		if err != nil {
+			return err
+		}
+		rds[i] = &RawData{Timestamp: rv.Timestamp, RawCodeID: id, DeviceID: deviceID, Value: rv.Value}
+	}
+	for _, d := range b.Data {
+		d.DeviceID = deviceID
+	}
+
+	err = w.db.Transaction(func(tx *gorm.DB) error {
+		if len(b.Data) > 0 {
+			if err := tx.Create(b.Data).Error; err != nil {
+				return err
+			}
+		}
+		if len(rds) > 0 {
+			if err := tx.Create(rds).Error; err != nil {
+				return err
+			}
+		}
+		if b.Energy != nil {
+			ev := summary.EnergyValues{}
+			for name, v := range b.Energy.Values {
+				if c, ok := summary.CounterByName(name); ok {
+					ev[c] = v
+				}
+			}
+			if err := (&DB{DB: tx, b: w.db.b}).AddEnergy(deviceID, b.Energy.Timestamp, ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// the IDs assigned before the transaction was rolled back must not be inserted on retry
+		for _, d := range b.Data {
+			d.ID = 0
+		}
+	}
+	return err
+}
+
+func (w *writer) deviceID(label string) (uint, error) {
+	if id, ok := w.deviceIDs[label]; ok {
+		return id, nil
+	}
+
+	id, err := w.db.DeviceID(label)
+	if err != nil {
+		return 0, err
+	}
+	w.deviceIDs[label] = id
+	return id, nil
+}
+
+func (w *writer) rawCodeID(code string, unit string) (uint, error) {
+	if id, ok := w.rawCodeIDs[code]; ok {
+		return id, nil
+	}
+
+	id, err := w.db.RawCodeID(code, unit)
+	if err != nil {
+		return 0, err
+	}
+	w.rawCodeIDs[code] = id
+	return id, nil
+}
+
+func (w *writer) run() {
+	defer close(w.done)
+
+	// spilled batches of a previous run are replayed right away
+	var retry <-chan time.Time
+	if err := w.flush(); err != nil {
+		retry = w.backoff(err)
+	}
+
+	for {
+		select {
+		case b, ok := <-w.batches:
+			if !ok {
+				if err := w.flush(); err != nil {
+					w.log.Warn().Err(err).Msg("writing to database failed")
+					w.spill(w.pending)
+				}
+				return
+			}
+			w.pending = append(w.pending, b)
+			if retry != nil {
+				w.trim()
+				continue
+			}
+		case <-retry:
+		}
+
+		retry = nil
+		if err := w.flush(); err != nil {
+			retry = w.backoff(err)
+		}
+	}
+}
+
+// flush replays the spill file and writes the pending batches afterwards. Since the oldest batches are spilled, they
+// are written in the order they were queued.
+func (w *writer) flush() error {
+	if err := w.replay(); err != nil {
+		return err
+	}
+
+	for len(w.pending) > 0 {
+		if err := w.store(w.pending[0]); err != nil {
+			return err
+		}
+		w.pending = w.pending[1:]
+	}
+	w.pending = nil
+
+	if w.attempt > 0 {
+		w.log.Info().Msg("writing to database recovered")
+	}
+	w.attempt = 0
+	return nil
+}
+
+func (w *writer) backoff(err error) <-chan time.Time {
+	w.attempt++
+	if w.c.Retry.MaxAttempts > 0 && w.attempt >= w.c.Retry.MaxAttempts {
+		w.spill(w.pending)
+		w.pending = nil
+	} else {
+		w.trim()
+	}
+
+	d := time.Duration(min(
+		float64(w.c.Retry.InitialInterval)*math.Pow(w.c.Retry.Multiplier, float64(w.attempt-1)),
+		float64(w.c.Retry.MaxInterval),
+	))
+	w.log.Warn().Err(err).Uint("attempt", w.attempt).Int("pending", len(w.pending)).Dur("retry_in", d).Msg("writing to database failed")
+	return time.After(d)
+}
+
+// trim spills the oldest pending batches that exceed the buffer size.
+func (w *writer) trim() {
+	if n := len(w.pending) - int(w.c.Size); n > 0 {
+		w.spill(w.pending[:n])
+		w.pending = w.pending[n:]
+	}
+}
+
+// spill appends the batches to the spill file. If no spill file is configured, the batches are dropped and reported as
+// lost.
+func (w *writer) spill(bs []*batch) {
+	if len(bs) == 0 {
+		return
+	}
+	if w.c.SpillPath == "" {
+		w.fail(fmt.Errorf("dropped %d writes, since no spill file is configured", len(bs)))
+		return
+	}
+
+	if err := appendSpill(w.c.SpillPath, bs); err != nil {
+		w.fail(err)
+		return
+	}
+	w.log.Warn().Int("batches", len(bs)).Str("path", w.c.SpillPath).Msg("spilled writes")
+}
+
+// replay writes the batches of the spill file and removes it afterwards. If a batch fails, the remaining ones are
+// kept in the spill file.
+func (w *writer) replay() error {
+	if w.c.SpillPath == "" {
+		return nil
+	}
+
+	bs, err := readSpill(w.c.SpillPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	w.log.Info().Int("batches", len(bs)).Str("path", w.c.SpillPath).Msg("replaying spilled writes")
+	for i, b := range bs {
+		if err := w.store(b); err != nil {
+			return errors.Join(err, writeSpill(w.c.SpillPath, bs[i:]))
+		}
+	}
+	return os.Remove(w.c.SpillPath)
+}
+
+func readSpill(path string) ([]*batch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bs := []*batch{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		b := &batch{}
+		if err := json.Unmarshal(scanner.Bytes(), b); err != nil {
+			return nil, err
+		}
+		bs = append(bs, b)
+	}
+	return bs, scanner.Err()
+}
+
+func appendSpill(path string, bs []*batch) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	return errors.Join(encodeSpill(f, bs), f.Close())
+}
+
+// writeSpill replaces the spill file atomically.
+func writeSpill(path string, bs []*batch) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if err := errors.Join(encodeSpill(f, bs), f.Close()); err != nil {
+		return errors.Join(err, os.Remove(f.Name()))
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func encodeSpill(f *os.File, bs []*batch) error {
+	enc := json.NewEncoder(f)
+	for _, b := range bs {
+		if err := enc.Encode(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
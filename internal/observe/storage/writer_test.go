@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/summary"
+	"github.com/rs/zerolog"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDBFromConfig(config.DatabaseConfig{
+		Driver:      config.SQLiteDatabaseDriver,
+		Path:        filepath.Join(t.TempDir(), "telescope.db"),
+		AutoMigrate: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.QuantityIDs([]summary.Quantity{summary.GridPower}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestWriter returns a writer without its goroutine, such that its steps can be run one by one.
+func newTestWriter(db *DB, c config.BufferConfig) *writer {
+	return &writer{db: db, c: c, log: zerolog.Nop(), deviceIDs: map[string]uint{}, rawCodeIDs: map[string]uint{}}
+}
+
+func testBufferConfig(t *testing.T) config.BufferConfig {
+	return config.BufferConfig{
+		Size:      10,
+		SpillPath: filepath.Join(t.TempDir(), "spill.jsonl"),
+		Retry:     config.RetryConfig{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond, Multiplier: 2},
+	}
+}
+
+func testBatch(v float32) *batch {
+	return &batch{
+		Device: "inverter",
+		Data:   []*Data{{Timestamp: time.Unix(int64(v), 0), QuantityID: 1, Value: v}},
+	}
+}
+
+func testBatches(vs ...float32) []*batch {
+	bs := make([]*batch, len(vs))
+	for i, v := range vs {
+		bs[i] = testBatch(v)
+	}
+	return bs
+}
+
+func storedValues(t *testing.T, db *DB) []float32 {
+	t.Helper()
+	vs := []float32{}
+	if err := db.Model(&Data{}).Order("id").Pluck("value", &vs).Error; err != nil {
+		t.Fatal(err)
+	}
+	return vs
+}
+
+func spilledValues(t *testing.T, path string) []float32 {
+	t.Helper()
+	bs, err := readSpill(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []float32{}
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	vs := []float32{}
+	for _, b := range bs {
+		for _, d := range b.Data {
+			vs = append(vs, d.Value)
+		}
+	}
+	return vs
+}
+
+func renameTable(t *testing.T, db *DB, from string, to string) {
+	t.Helper()
+	if err := db.Exec("ALTER TABLE " + from + " RENAME TO " + to).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriterFlushOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		spilled []float32
+		pending []float32
+		want    []float32
+	}{
+		{"pending", nil, []float32{1, 2, 3}, []float32{1, 2, 3}},
+		{"spilled", []float32{1, 2, 3}, nil, []float32{1, 2, 3}},
+		{"spilled before pending", []float32{1, 2}, []float32{3, 4}, []float32{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			w := newTestWriter(db, testBufferConfig(t))
+			if len(tt.spilled) > 0 {
+				if err := appendSpill(w.c.SpillPath, testBatches(tt.spilled...)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w.pending = testBatches(tt.pending...)
+
+			if err := w.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := storedValues(t, db); !slices.Equal(got, tt.want) {
+				t.Errorf("stored %v, want %v", got, tt.want)
+			}
+			if len(w.pending) > 0 {
+				t.Errorf("%d batches are still pending", len(w.pending))
+			}
+			if _, err := os.Stat(w.c.SpillPath); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("spill file was not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriterTrim(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        uint
+		pending     []float32
+		wantSpilled []float32
+		wantPending int
+	}{
+		{"below size", 3, []float32{1, 2}, []float32{}, 2},
+		{"at size", 2, []float32{1, 2}, []float32{}, 2},
+		{"above size", 2, []float32{1, 2, 3, 4}, []float32{1, 2}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			c := testBufferConfig(t)
+			c.Size = tt.size
+			w := newTestWriter(db, c)
+			w.pending = testBatches(tt.pending...)
+
+			w.trim()
+			if got := spilledValues(t, c.SpillPath); !slices.Equal(got, tt.wantSpilled) {
+				t.Errorf("spilled %v, want %v", got, tt.wantSpilled)
+			}
+			if len(w.pending) != tt.wantPending {
+				t.Errorf("%d batches are pending, want %d", len(w.pending), tt.wantPending)
+			}
+
+			// the oldest batches are spilled, such that all batches are stored in order afterwards
+			if err := w.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := storedValues(t, db); !slices.Equal(got, tt.pending) {
+				t.Errorf("stored %v, want %v", got, tt.pending)
+			}
+		})
+	}
+}
+
+func TestWriterOutage(t *testing.T) {
+	db := newTestDB(t)
+	c := testBufferConfig(t)
+	c.Retry.MaxAttempts = 2
+	w := newTestWriter(db, c)
+
+	// the data is inserted before the raw data fails, such that the transaction is rolled back after IDs are assigned
+	renameTable(t, db, "raw_data", "raw_data_offline")
+	bs := testBatches(1, 2)
+	for _, b := range bs {
+		b.Raw = []*rawValue{{Timestamp: b.Data[0].Timestamp, Code: "I18N_GRID_POWER", Unit: "W", Value: float64(b.Data[0].Value)}}
+	}
+	w.pending = bs
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		err := w.flush()
+		if err == nil {
+			t.Fatal("flush succeeded while the database is unavailable")
+		}
+		w.backoff(err)
+	}
+	if got, want := spilledValues(t, c.SpillPath), []float32{1, 2}; !slices.Equal(got, want) {
+		t.Errorf("spilled %v after the last attempt, want %v", got, want)
+	}
+	if len(w.pending) > 0 {
+		t.Errorf("%d batches are still pending after the last attempt", len(w.pending))
+	}
+	// the IDs of the rolled back transaction might be taken by other rows until the batches are replayed
+	for _, b := range bs {
+		if id := b.Data[0].ID; id != 0 {
+			t.Errorf("batch %v keeps the ID %d of the failed write", b.Data[0].Value, id)
+		}
+	}
+
+	renameTable(t, db, "raw_data_offline", "raw_data")
+	w.pending = testBatches(3)
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := storedValues(t, db), []float32{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("stored %v after recovering, want %v", got, want)
+	}
+	var n int64
+	if err := db.Model(&RawData{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("stored %d raw values, want 2", n)
+	}
+}
+
+func TestWriterSpillsOnClose(t *testing.T) {
+	db := newTestDB(t)
+	c := testBufferConfig(t)
+
+	renameTable(t, db, "data", "data_offline")
+	w := newWriter(db, c, zerolog.Nop())
+	for _, b := range testBatches(1, 2) {
+		if err := w.write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := spilledValues(t, c.SpillPath), []float32{1, 2}; !slices.Equal(got, want) {
+		t.Errorf("spilled %v on close, want %v", got, want)
+	}
+
+	// the spill file is replayed once the writer is started again
+	renameTable(t, db, "data_offline", "data")
+	w = newWriter(db, c, zerolog.Nop())
+	if err := w.write(testBatch(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := storedValues(t, db), []float32{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("stored %v after restarting, want %v", got, want)
+	}
+}
+
+func TestWriterReportsErrorOnce(t *testing.T) {
+	db := newTestDB(t)
+	w := newWriter(db, testBufferConfig(t), zerolog.Nop())
+
+	lost := errors.New("lost")
+	w.fail(lost)
+	if err := w.write(testBatch(1)); !errors.Is(err, lost) {
+		t.Errorf("first write returned %v, want %v", err, lost)
+	}
+	if err := w.write(testBatch(2)); err != nil {
+		t.Errorf("second write returned %v, want nil", err)
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := storedValues(t, db), []float32{1, 2}; !slices.Equal(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+}
+
+func TestWriterReportsDroppedWrites(t *testing.T) {
+	db := newTestDB(t)
+	c := testBufferConfig(t)
+	c.Size = 1
+	c.SpillPath = ""
+	w := newTestWriter(db, c)
+	w.pending = testBatches(1, 2, 3)
+
+	w.trim()
+	if err := w.takeError(); err == nil || !strings.Contains(err.Error(), "dropped 2 writes") {
+		t.Errorf("trimming returned %v, want the dropped writes", err)
+	}
+	if len(w.pending) != 1 {
+		t.Errorf("%d batches are pending, want 1", len(w.pending))
+	}
+}
+
+func TestSpillRoundTrip(t *testing.T) {
+	ts := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		bs   []*batch
+	}{
+		{"data", []*batch{{Device: "inverter", Data: []*Data{{Timestamp: ts, QuantityID: 1, Value: 1.5}}}}},
+		{"raw", []*batch{{Device: "inverter", Raw: []*rawValue{{Timestamp: ts, Code: "I18N_GRID_POWER", Unit: "W", Value: 2.5}}}}},
+		{"energy", []*batch{{Device: "inverter", Energy: &energyDelta{Timestamp: ts, Values: map[string]float64{"pv_yield": 0.25}}}}},
+		{"multiple", []*batch{
+			{Device: "a", Data: []*Data{{Timestamp: ts, QuantityID: 1, Value: 1}}},
+			{Device: "b", Data: []*Data{{Timestamp: ts.Add(time.Second), QuantityID: 2, Value: 2}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "spill.jsonl")
+
+			// appending twice continues the file
+			if err := appendSpill(path, tt.bs); err != nil {
+				t.Fatal(err)
+			}
+			if err := appendSpill(path, tt.bs); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readSpill(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append(slices.Clone(tt.bs), tt.bs...); !reflect.DeepEqual(got, want) {
+				t.Errorf("read %+v after appending, want %+v", got, want)
+			}
+
+			// writing replaces the file
+			if err := writeSpill(path, tt.bs); err != nil {
+				t.Fatal(err)
+			}
+			got, err = readSpill(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.bs) {
+				t.Errorf("read %+v after writing, want %+v", got, tt.bs)
+			}
+		})
+	}
+}
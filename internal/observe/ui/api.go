@@ -0,0 +1,159 @@
+package ui
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pmeier/telescope/internal/observe/storage"
+	"github.com/pmeier/telescope/internal/summary"
+)
+
+// device returns the device requested by the query parameter device and falls back to the default one.
+func device(s *Server, c echo.Context) string {
+	if c.QueryParams().Has("device") {
+		return c.QueryParam("device")
+	}
+	return s.defaultDevice()
+}
+
+// apiLatestSummary serves the latest summary of a device. The query parameter device defaults to the total if
+// multiple devices are observed.
+func apiLatestSummary(s *Server) (string, string, echo.HandlerFunc) {
+	return http.MethodGet, "/api/v1/summary/latest", func(c echo.Context) error {
+		d := device(s, c)
+
+		s.mu.Lock()
+		latest := s.latest[d]
+		s.mu.Unlock()
+
+		if latest == nil {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "no summary available yet")
+		}
+		return c.JSON(http.StatusOK, latest)
+	}
+}
+
+type seriesResponse struct {
+	Device   string          `json:"device,omitempty"`
+	Quantity string          `json:"quantity"`
+	Unit     string          `json:"unit"`
+	From     time.Time       `json:"from"`
+	To       time.Time       `json:"to"`
+	Step     string          `json:"step,omitempty"`
+	Points   []storage.Point `json:"points"`
+}
+
+// apiQuantitySeries serves the stored values of a quantity. The query parameters from and to are RFC 3339 timestamps
+// and default to the last 24 hours. If the step duration, e.g. 5m, is given, the values are averaged over buckets of
+// that size. Since values are only stored when they change, the average is the mean of the changes in a bucket and not
+// the average over time.
+func apiQuantitySeries(s *Server) (string, string, echo.HandlerFunc) {
+	return http.MethodGet, "/api/v1/quantities/:name/series", func(c echo.Context) error {
+		if s.db == nil {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "storage is not enabled")
+		}
+
+		q, ok := summary.QuantityByName(c.Param("name"))
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotFound, "unknown quantity")
+		}
+
+		to := time.Now()
+		if v := c.QueryParam("to"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid to timestamp")
+			}
+			to = t
+		}
+
+		from := to.Add(-time.Hour * 24)
+		if v := c.QueryParam("from"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid from timestamp")
+			}
+			from = t
+		}
+		if !from.Before(to) {
+			return echo.NewHTTPError(http.StatusBadRequest, "from has to be before to")
+		}
+
+		var step time.Duration
+		if v := c.QueryParam("step"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < time.Second {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid step duration")
+			}
+			step = d
+		}
+
+		d := device(s, c)
+		ps, err := s.db.Series(d, q.Name(), from, to, step)
+		if err != nil {
+			return err
+		}
+
+		r := seriesResponse{Device: d, Quantity: q.Name(), Unit: q.Unit(), From: from, To: to, Points: ps}
+		if step > 0 {
+			r.Step = step.String()
+		}
+		return c.JSON(http.StatusOK, r)
+	}
+}
+
+type energyResponse struct {
+	Device string             `json:"device,omitempty"`
+	Period string             `json:"period"`
+	Date   string             `json:"date"`
+	Energy map[string]float64 `json:"energy"`
+	Ratios map[string]float64 `json:"ratios"`
+}
+
+// apiEnergy serves the energy totals and ratios of a day or month. The query parameter period is either day (default)
+// or month and date is a YYYY-MM-DD date within the period, which defaults to today.
+func apiEnergy(s *Server) (string, string, echo.HandlerFunc) {
+	return http.MethodGet, "/api/v1/energy", func(c echo.Context) error {
+		if s.db == nil {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "storage is not enabled")
+		}
+
+		t := time.Now()
+		if v := c.QueryParam("date"); v != "" {
+			d, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid date")
+			}
+			t = d
+		}
+
+		var (
+			ev  summary.EnergyValues
+			err error
+		)
+		d := device(s, c)
+		period := c.QueryParam("period")
+		switch period {
+		case "", "day":
+			period = "day"
+			ev, err = s.db.DailyEnergy(d, t)
+		case "month":
+			ev, err = s.db.MonthlyEnergy(d, t)
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid period")
+		}
+		if err != nil {
+			return err
+		}
+
+		r := energyResponse{Device: d, Period: period, Date: t.Format(time.DateOnly), Energy: map[string]float64{}, Ratios: map[string]float64{}}
+		for _, c := range summary.Counters() {
+			r.Energy[c.Name()] = ev[c]
+		}
+		for q, v := range summary.EnergyRatios(ev) {
+			r.Ratios[q.Name()] = float64(v)
+		}
+		return c.JSON(http.StatusOK, r)
+	}
+}
@@ -0,0 +1,47 @@
+package ui
+
+import (
+	"time"
+
+	"github.com/pmeier/telescope/internal/summary"
+)
+
+// energyWindow accumulates the energy over a calendar period. The period is identified by formatting a timestamp with
+// the layout, e.g. time.DateOnly for days.
+type energyWindow struct {
+	layout string
+	period string
+	totals summary.EnergyValues
+}
+
+func newEnergyWindow(layout string) *energyWindow {
+	return &energyWindow{layout: layout, totals: summary.EnergyValues{}}
+}
+
+func (w *energyWindow) seed(t time.Time, ev summary.EnergyValues) {
+	w.period = t.Format(w.layout)
+	w.totals = ev
+}
+
+func (w *energyWindow) add(t time.Time, ev summary.EnergyValues) {
+	if p := t.Format(w.layout); p != w.period {
+		w.period = p
+		w.totals = summary.EnergyValues{}
+	}
+
+	for c, v := range ev {
+		w.totals[c] += v
+	}
+}
+
+// data returns the totals as well as the energy ratios keyed by name.
+func (w *energyWindow) data() map[string]float64 {
+	d := make(map[string]float64, len(summary.Counters())+2)
+	for _, c := range summary.Counters() {
+		d[c.Name()] = w.totals[c]
+	}
+	for q, v := range summary.EnergyRatios(w.totals) {
+		d[q.Name()] = float64(v)
+	}
+	return d
+}
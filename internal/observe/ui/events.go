@@ -0,0 +1,157 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pmeier/telescope/internal/summary"
+)
+
+type serverSentEvent struct {
+	name   string
+	device string
+	data   []byte
+}
+
+// eventStream holds the events that are pending for a client. A pending event is replaced by a newer one with the same
+// name and device, such that a slow client only misses outdated events.
+type eventStream struct {
+	mu      sync.Mutex
+	pending []serverSentEvent
+	notify  chan struct{}
+	done    chan struct{}
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{notify: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+func (es *eventStream) push(e serverSentEvent) {
+	es.mu.Lock()
+	i := slices.IndexFunc(es.pending, func(p serverSentEvent) bool {
+		return p.name == e.name && p.device == e.device
+	})
+	if i >= 0 {
+		es.pending[i] = e
+	} else {
+		es.pending = append(es.pending, e)
+	}
+	es.mu.Unlock()
+
+	select {
+	case es.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending events in order and removes them.
+func (es *eventStream) take() []serverSentEvent {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	pending := es.pending
+	es.pending = nil
+	return pending
+}
+
+// events streams every summary as JSON encoded server-sent event named "summary". Changes of the source availability
+// are sent as "status" events.
+func events(s *Server) (string, string, echo.HandlerFunc) {
+	return http.MethodGet, "/events", func(c echo.Context) error {
+		w := c.Response()
+		w.Header().Set(echo.HeaderContentType, "text/event-stream")
+		w.Header().Set(echo.HeaderCacheControl, "no-cache")
+		w.Header().Set(echo.HeaderConnection, "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		w.Flush()
+
+		id := uuid.New()
+		log := s.log.With().Str("origin", c.RealIP()).Stringer("id", id).Logger()
+
+		es := newEventStream()
+
+		// the latest summaries of all devices are sent right away, such that clients do not have to wait for the next
+		// ones
+		s.mu.Lock()
+		for _, label := range s.labels {
+			if sm, ok := s.latest[label]; ok {
+				if e, err := newServerSentEvent("summary", label, sm); err == nil {
+					es.push(e)
+				}
+			}
+		}
+		s.sses[id] = es
+		s.mu.Unlock()
+		log.Info().Msg("event stream connected")
+
+		defer func() {
+			s.mu.Lock()
+			delete(s.sses, id)
+			s.mu.Unlock()
+			log.Info().Msg("event stream closed")
+		}()
+
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case <-es.done:
+				return nil
+			case <-es.notify:
+				for _, e := range es.take() {
+					if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data); err != nil {
+						return nil
+					}
+				}
+				w.Flush()
+			}
+		}
+	}
+}
+
+func newServerSentEvent(name string, device string, v any) (serverSentEvent, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return serverSentEvent{}, err
+	}
+	return serverSentEvent{name: name, device: device, data: data}, nil
+}
+
+func (s *Server) broadcastEvent(name string, device string, v any) {
+	e, err := newServerSentEvent(name, device, v)
+	if err != nil {
+		s.log.Error().Err(err).Send()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, es := range s.sses {
+		es.push(e)
+	}
+}
+
+// CloseEvents ends all event streams.
+func (s *Server) CloseEvents() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, es := range s.sses {
+		close(es.done)
+		delete(s.sses, id)
+	}
+}
+
+type statusEvent struct {
+	Device string `json:"device,omitempty"`
+	Status string `json:"status"`
+}
+
+func newStatusEvent(device string, status summary.Status) statusEvent {
+	return statusEvent{Device: device, Status: status.String()}
+}
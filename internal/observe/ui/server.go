@@ -6,11 +6,15 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/pmeier/telescope/internal/config"
 	"github.com/pmeier/telescope/internal/health"
+	"github.com/pmeier/telescope/internal/metrics"
+	"github.com/pmeier/telescope/internal/observe/storage"
 	"github.com/pmeier/telescope/internal/summary"
 	"github.com/rs/zerolog"
 
@@ -18,12 +22,39 @@ import (
 )
 
 type Server struct {
-	log  zerolog.Logger
-	tg   *TemplateGroup
-	data map[string]any
+	log zerolog.Logger
+	tg  *TemplateGroup
+	db  *storage.DB
 	*echo.Echo
-	wss map[uuid.UUID]*websocket.Conn
-	mu  sync.Mutex
+	wss    map[uuid.UUID]*websocket.Conn
+	sses   map[uuid.UUID]*eventStream
+	panels map[string]*panel
+	// labels holds the devices in the order they first reported
+	labels []string
+	latest map[string]*summary.Summary
+	mu     sync.Mutex
+}
+
+// panel holds the template data of a single device.
+type panel struct {
+	data            map[string]any
+	energyToday     *energyWindow
+	energyThisMonth *energyWindow
+}
+
+func newPanel(device string) *panel {
+	p := &panel{
+		data:            map[string]any{"Device": device},
+		energyToday:     newEnergyWindow(time.DateOnly),
+		energyThisMonth: newEnergyWindow("2006-01"),
+	}
+	p.updateEnergy()
+	return p
+}
+
+func (p *panel) updateEnergy() {
+	p.data["EnergyToday"] = p.energyToday.data()
+	p.data["EnergyThisMonth"] = p.energyThisMonth.data()
 }
 
 type routeFunc = func(*Server) (string, string, echo.HandlerFunc)
@@ -38,7 +69,8 @@ func (tg *TemplateGroup) Render(wr io.Writer, name string, data any, c echo.Cont
 	return tg.ExecuteTemplate(wr, name, data)
 }
 
-func NewServer(log zerolog.Logger) *Server {
+// NewServer creates the UI server. db is used to serve historical values and may be nil if storage is not enabled.
+func NewServer(log zerolog.Logger, db *storage.DB) *Server {
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
@@ -50,12 +82,26 @@ func NewServer(log zerolog.Logger) *Server {
 	tg.ParseFS(templatesFS, "templates")
 	e.Renderer = tg
 
-	s := &Server{log: log, tg: tg, data: map[string]any{}, Echo: e, wss: map[uuid.UUID]*websocket.Conn{}}
+	s := &Server{
+		log:    log,
+		tg:     tg,
+		db:     db,
+		Echo:   e,
+		wss:    map[uuid.UUID]*websocket.Conn{},
+		sses:   map[uuid.UUID]*eventStream{},
+		panels: map[string]*panel{},
+		latest: map[string]*summary.Summary{},
+	}
 
 	routeFuncs := []routeFunc{
 		wrapBasicRouteFunc(health.HealthRouteFunc),
+		wrapBasicRouteFunc(metrics.MetricsRouteFunc),
 		index,
 		ws,
+		events,
+		apiLatestSummary,
+		apiQuantitySeries,
+		apiEnergy,
 	}
 	for _, routeFunc := range routeFuncs {
 		method, path, handler := routeFunc(s)
@@ -64,7 +110,8 @@ func NewServer(log zerolog.Logger) *Server {
 
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		Skipper: func(c echo.Context) bool {
-			return c.Request().URL.Path == "/health" && log.GetLevel() > zerolog.DebugLevel
+			path := c.Request().URL.Path
+			return (path == "/health" || path == "/metrics") && log.GetLevel() > zerolog.DebugLevel
 		},
 		LogRemoteIP: true,
 		LogURI:      true,
@@ -91,10 +138,48 @@ func wrapBasicRouteFunc(basicRouteFunc func() (string, string, echo.HandlerFunc)
 
 func index(s *Server) (string, string, echo.HandlerFunc) {
 	return http.MethodGet, "/", func(c echo.Context) error {
-		return c.Render(http.StatusOK, "views/index.html", s.data)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return c.Render(http.StatusOK, "views/index.html", s.templateData())
 	}
 }
 
+// templateData returns the data of all panels and has to be called with the lock held.
+func (s *Server) templateData() map[string]any {
+	panels := make([]map[string]any, 0, len(s.labels))
+	for _, label := range s.labels {
+		panels = append(panels, s.panels[label].data)
+	}
+	return map[string]any{"Panels": panels}
+}
+
+// panel returns the panel of the device and creates it if the device did not report before. It has to be called with
+// the lock held.
+func (s *Server) panel(device string) *panel {
+	p, ok := s.panels[device]
+	if !ok {
+		p = newPanel(device)
+		s.panels[device] = p
+		s.labels = append(s.labels, device)
+	}
+	return p
+}
+
+// defaultDevice returns the device that is served if none is requested, i.e. the total if there are multiple devices.
+func (s *Server) defaultDevice() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.panels[config.TotalLabel]; ok {
+		return config.TotalLabel
+	}
+	if len(s.labels) > 0 {
+		return s.labels[0]
+	}
+	return ""
+}
+
 func ws(s *Server) (string, string, echo.HandlerFunc) {
 	upgrader := websocket.Upgrader{}
 
@@ -110,6 +195,7 @@ func ws(s *Server) (string, string, echo.HandlerFunc) {
 
 		s.mu.Lock()
 		s.wss[id] = ws
+		metrics.SetWebsocketClients(len(s.wss))
 		s.mu.Unlock()
 		log.Info().Msg("websocket connected")
 
@@ -126,6 +212,7 @@ func ws(s *Server) (string, string, echo.HandlerFunc) {
 
 		s.mu.Lock()
 		delete(s.wss, id)
+		metrics.SetWebsocketClients(len(s.wss))
 		s.mu.Unlock()
 		ws.Close()
 
@@ -135,21 +222,88 @@ func ws(s *Server) (string, string, echo.HandlerFunc) {
 	}
 }
 
+// CloseWebsockets sends a close frame to all connected websockets. The connections are cleaned up once the browsers
+// respond.
+func (s *Server) CloseWebsockets() {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	deadline := time.Now().Add(time.Second)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ws := range s.wss {
+		err := ws.WriteControl(websocket.CloseMessage, msg, deadline)
+		if err != nil && err != websocket.ErrCloseSent {
+			s.log.Error().Err(err).Send()
+		}
+	}
+}
+
+// HasDevice reports whether a summary of the device was received before.
+func (s *Server) HasDevice(device string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.latest[device]
+	return ok
+}
+
 func (s *Server) UpdateData(sm *summary.Summary) {
-	s.data["TimeStamp"] = sm.Timestamp
-	s.data["GridPower"] = sm.Values[summary.GridPower]
-	s.data["BatteryPower"] = sm.Values[summary.BatteryPower]
-	s.data["PVPower"] = sm.Values[summary.PVPower]
-	s.data["LoadPower"] = sm.Values[summary.LoadPower]
-	s.data["BatteryLevel"] = sm.Values[summary.BatteryLevel]
+	s.mu.Lock()
+	p := s.panel(sm.Device)
+	p.data["TimeStamp"] = sm.Timestamp
+	p.data["GridPower"] = sm.Values[summary.GridPower]
+	p.data["BatteryPower"] = sm.Values[summary.BatteryPower]
+	p.data["PVPower"] = sm.Values[summary.PVPower]
+	p.data["LoadPower"] = sm.Values[summary.LoadPower]
+	p.data["BatteryLevel"] = sm.Values[summary.BatteryLevel]
+	p.data["GridImportPower"] = sm.Values[summary.GridImportPower]
+	p.data["GridExportPower"] = sm.Values[summary.GridExportPower]
+	p.data["BatteryChargePower"] = sm.Values[summary.BatteryChargePower]
+	p.data["BatteryDischargePower"] = sm.Values[summary.BatteryDischargePower]
+	p.data["Violations"] = sm.Violations
+	p.data["Autarky"] = sm.Values[summary.Autarky]
+	p.data["SelfConsumptionRate"] = sm.Values[summary.SelfConsumptionRate]
 
-	var b bytes.Buffer
-	s.tg.ExecuteTemplate(&b, "components/summary.html", &s.data)
-	data := b.Bytes()
+	p.energyToday.add(sm.Timestamp, sm.Energy)
+	p.energyThisMonth.add(sm.Timestamp, sm.Energy)
+	p.updateEnergy()
+
+	s.latest[sm.Device] = sm
+	s.mu.Unlock()
 
+	s.broadcastSummary()
+	s.broadcastEvent("summary", sm.Device, sm)
+}
+
+// SeedEnergy sets the energy totals of the device for the day and month of t, e.g. from previous runs.
+func (s *Server) SeedEnergy(device string, t time.Time, day summary.EnergyValues, month summary.EnergyValues) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.panel(device)
+	p.energyToday.seed(t, day)
+	p.energyThisMonth.seed(t, month)
+	p.updateEnergy()
+}
+
+func (s *Server) UpdateStatus(device string, status summary.Status) {
+	s.mu.Lock()
+	s.panel(device).data["Unavailable"] = status == summary.Unavailable
+	s.mu.Unlock()
+
+	s.broadcastSummary()
+	s.broadcastEvent("status", device, newStatusEvent(device, status))
+}
+
+func (s *Server) broadcastSummary() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	var b bytes.Buffer
+	s.tg.ExecuteTemplate(&b, "components/summaries.html", s.templateData())
+	data := b.Bytes()
+
 	for _, ws := range s.wss {
 		err := ws.WriteMessage(websocket.TextMessage, data)
 		if err != nil && err != websocket.ErrCloseSent {
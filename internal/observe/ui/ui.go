@@ -1,22 +1,30 @@
 package ui
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/pmeier/telescope/internal/config"
 	"github.com/pmeier/telescope/internal/health"
+	"github.com/pmeier/telescope/internal/observe/storage"
 	"github.com/pmeier/telescope/internal/summary"
 	"github.com/rs/zerolog"
 )
 
 type UISummaryHandler struct {
-	s *Server
+	s  *Server
+	db *storage.DB
+}
+
+// SetDB sets the database historical values are served from. The database is owned by the caller.
+func (sh *UISummaryHandler) SetDB(db *storage.DB) {
+	sh.db = db
 }
 
 func (sh *UISummaryHandler) Setup(c config.ObserveConfig, log zerolog.Logger, s summary.Summary) error {
 	uc := c.UI
-	sh.s = NewServer(log)
+	sh.s = NewServer(log, sh.db)
 
 	host := uc.Host
 	port := uc.Port
@@ -32,10 +40,37 @@ func (sh *UISummaryHandler) Setup(c config.ObserveConfig, log zerolog.Logger, s
 	}
 
 	log.Info().Msg("started")
-	return nil
+
+	return sh.Handle(s)
 }
 
 func (sh *UISummaryHandler) Handle(s summary.Summary) error {
+	if sh.db != nil && !sh.s.HasDevice(s.Device) {
+		day, err := sh.db.DailyEnergy(s.Device, s.Timestamp)
+		if err != nil {
+			return err
+		}
+		month, err := sh.db.MonthlyEnergy(s.Device, s.Timestamp)
+		if err != nil {
+			return err
+		}
+		sh.s.SeedEnergy(s.Device, s.Timestamp, day, month)
+	}
+
 	sh.s.UpdateData(&s)
 	return nil
 }
+
+func (sh *UISummaryHandler) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	sh.s.CloseWebsockets()
+	sh.s.CloseEvents()
+	return sh.s.Shutdown(ctx)
+}
+
+func (sh *UISummaryHandler) HandleStatus(device string, status summary.Status) error {
+	sh.s.UpdateStatus(device, status)
+	return nil
+}
@@ -0,0 +1,43 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/pmeier/telescope/internal/observe/storage"
+)
+
+// Run applies the retention of the storage once and prints what was done. For a dry run, nothing is changed.
+func Run(ctx context.Context, c config.Config) error {
+	rc := c.Observe.Storage.Retention
+
+	db, err := storage.NewDBFromConfig(c.Observe.Storage.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	r, err := db.ApplyRetention(ctx, rc, time.Now(), rc.DryRun)
+	if err != nil {
+		return err
+	}
+
+	if r.DryRun {
+		fmt.Println("dry run, nothing was changed")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tSTEP\tROLLED UP\tDELETED\tBEFORE")
+	before := "-"
+	if !r.Before.IsZero() {
+		before = r.Before.Local().Format(time.DateTime)
+	}
+	fmt.Fprintf(w, "data\t-\t-\t%d\t%s\n", r.Deleted, before)
+	for _, rr := range r.Rollups {
+		fmt.Fprintf(w, "data_rollups\t%s\t%d\t%d\t-\n", rr.Step, rr.RolledUp, rr.Deleted)
+	}
+	return w.Flush()
+}
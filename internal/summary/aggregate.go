@@ -0,0 +1,27 @@
+package summary
+
+// Aggregate combines the summaries of multiple devices into one. Powers, i.e. quantities in watts, are summed up and
+// all other quantities are averaged over the devices that report them. The derived quantities are computed again from
+// the aggregated values.
+func Aggregate(device string, ss []Summary) Summary {
+	a := Summary{Device: device, Values: SummaryValues{}}
+	counts := map[Quantity]int{}
+	for _, s := range ss {
+		if s.Timestamp.After(a.Timestamp) {
+			a.Timestamp = s.Timestamp
+		}
+		for q, v := range s.Values {
+			a.Values[q] += v
+			counts[q]++
+		}
+	}
+
+	for q, n := range counts {
+		if q.Unit() != "watts" {
+			a.Values[q] /= float32(n)
+		}
+	}
+	Derive(a.Values)
+
+	return a
+}
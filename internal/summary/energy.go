@@ -0,0 +1,111 @@
+package summary
+
+import (
+	"time"
+)
+
+type Counter uint8
+
+const (
+	PVYield Counter = iota
+	GridImport
+	GridExport
+	BatteryCharge
+	BatteryDischarge
+	SelfConsumption
+	Consumption
+)
+
+func (c Counter) Name() string {
+	return map[Counter]string{
+		PVYield:          "pv_yield",
+		GridImport:       "grid_import",
+		GridExport:       "grid_export",
+		BatteryCharge:    "battery_charge",
+		BatteryDischarge: "battery_discharge",
+		SelfConsumption:  "self_consumption",
+		Consumption:      "consumption",
+	}[c]
+}
+
+func (c Counter) Unit() string {
+	return "watt_hours"
+}
+
+func (c Counter) String() string {
+	return c.Name()
+}
+
+func Counters() []Counter {
+	return []Counter{
+		PVYield,
+		GridImport,
+		GridExport,
+		BatteryCharge,
+		BatteryDischarge,
+		SelfConsumption,
+		Consumption,
+	}
+}
+
+func CounterByName(name string) (Counter, bool) {
+	for _, c := range Counters() {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+type EnergyValues map[Counter]float64
+
+// counterPowers returns the power feeding each counter.
+func counterPowers(vs SummaryValues) map[Counter]float64 {
+	pv := float64(vs[PVPower])
+	export := float64(vs[GridExportPower])
+
+	return map[Counter]float64{
+		PVYield:          pv,
+		GridImport:       float64(vs[GridImportPower]),
+		GridExport:       export,
+		BatteryCharge:    float64(vs[BatteryChargePower]),
+		BatteryDischarge: float64(vs[BatteryDischargePower]),
+		SelfConsumption:  max(pv-export, 0),
+		Consumption:      float64(vs[LoadPower]),
+	}
+}
+
+// Integrator integrates the power of consecutive summaries into energy using the trapezoidal rule.
+type Integrator struct {
+	maxGap time.Duration
+	last   *Summary
+}
+
+// NewIntegrator creates an Integrator that does not integrate over gaps between summaries longer than maxGap, since
+// the power cannot be assumed to change linearly over them.
+func NewIntegrator(maxGap time.Duration) *Integrator {
+	return &Integrator{maxGap: maxGap}
+}
+
+// Integrate returns the energy in watt hours since the previous summary. It returns nil for the first summary and
+// after gaps.
+func (i *Integrator) Integrate(s Summary) EnergyValues {
+	last := i.last
+	i.last = &s
+	if last == nil {
+		return nil
+	}
+
+	d := s.Timestamp.Sub(last.Timestamp)
+	if d <= 0 || d > i.maxGap {
+		return nil
+	}
+
+	p0 := counterPowers(last.Values)
+	p1 := counterPowers(s.Values)
+	ev := make(EnergyValues, len(p1))
+	for c, p := range p1 {
+		ev[c] = (p0[c] + p) / 2 * d.Hours()
+	}
+	return ev
+}
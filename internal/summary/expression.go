@@ -0,0 +1,211 @@
+package summary
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is an arithmetic expression over I18N codes, e.g. "(I18N_CONFIG_KEY_4060 - I18N_CONFIG_KEY_3907) * 1e3".
+// It supports numbers, the operators +, -, *, /, and parentheses. Codes missing from the measurements evaluate to 0 and
+// so does a division by 0, such that the values stay finite.
+type Expression struct {
+	src  string
+	eval func(map[string]float64) float64
+}
+
+func ParseExpression(src string) (*Expression, error) {
+	p := &expressionParser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	eval, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != eofToken {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+
+	return &Expression{src: src, eval: eval}, nil
+}
+
+func (e *Expression) Eval(vs map[string]float64) float64 {
+	return e.eval(vs)
+}
+
+func (e *Expression) String() string {
+	return e.src
+}
+
+type tokenKind uint8
+
+const (
+	eofToken tokenKind = iota
+	numberToken
+	codeToken
+	operatorToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type expressionParser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *expressionParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("invalid expression %q at position %d: %s", p.src, p.tok.pos, fmt.Sprintf(format, a...))
+}
+
+func (p *expressionParser) next() error {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.src) {
+		p.tok = token{kind: eofToken, pos: start}
+		return nil
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.IndexByte("+-*/()", c) >= 0:
+		p.pos++
+		p.tok = token{kind: operatorToken, text: string(c), pos: start}
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.' || p.src[p.pos] == 'e' || p.src[p.pos] == 'E' ||
+			(p.src[p.pos] == '-' || p.src[p.pos] == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+			p.pos++
+		}
+		p.tok = token{kind: numberToken, text: p.src[start:p.pos], pos: start}
+	case isCodeChar(c):
+		for p.pos < len(p.src) && (isCodeChar(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: codeToken, text: p.src[start:p.pos], pos: start}
+	default:
+		p.tok = token{pos: start}
+		return p.errorf("unexpected character %q", c)
+	}
+	return nil
+}
+
+func (p *expressionParser) isOperator(ops string) bool {
+	return p.tok.kind == operatorToken && strings.Contains(ops, p.tok.text)
+}
+
+// sum = product { ("+" | "-") product }
+func (p *expressionParser) sum() (func(map[string]float64) float64, error) {
+	lhs, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("+-") {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		rhs, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+
+		l := lhs
+		if op == "+" {
+			lhs = func(vs map[string]float64) float64 { return l(vs) + rhs(vs) }
+		} else {
+			lhs = func(vs map[string]float64) float64 { return l(vs) - rhs(vs) }
+		}
+	}
+	return lhs, nil
+}
+
+// product = unary { ("*" | "/") unary }
+func (p *expressionParser) product() (func(map[string]float64) float64, error) {
+	lhs, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("*/") {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		rhs, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		l := lhs
+		if op == "*" {
+			lhs = func(vs map[string]float64) float64 { return l(vs) * rhs(vs) }
+		} else {
+			lhs = func(vs map[string]float64) float64 {
+				d := rhs(vs)
+				if d == 0 {
+					return 0
+				}
+				return l(vs) / d
+			}
+		}
+	}
+	return lhs, nil
+}
+
+// unary = "-" unary | number | code | "(" sum ")"
+func (p *expressionParser) unary() (func(map[string]float64) float64, error) {
+	tok := p.tok
+	switch {
+	case p.isOperator("-"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(vs map[string]float64) float64 { return -operand(vs) }, nil
+	case p.isOperator("("):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		inner, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOperator(")") {
+			return nil, p.errorf("expected \")\"")
+		}
+		return inner, p.next()
+	case tok.kind == numberToken:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		return func(map[string]float64) float64 { return v }, p.next()
+	case tok.kind == codeToken:
+		return func(vs map[string]float64) float64 { return vs[tok.text] }, p.next()
+	case tok.kind == eofToken:
+		return nil, p.errorf("unexpected end")
+	default:
+		return nil, p.errorf("unexpected %q", tok.text)
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isCodeChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '_'
+}
@@ -0,0 +1,99 @@
+package summary
+
+import "testing"
+
+func TestExpressionEval(t *testing.T) {
+	vs := map[string]float64{
+		"I18N_A": 2,
+		"I18N_B": 3,
+		"I18N_Z": 0,
+		"a_1":    5,
+	}
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		{"42", 42},
+		{"1.5", 1.5},
+		{".5", 0.5},
+		{"1e3", 1000},
+		{"2.5E-1", 0.25},
+		{"1e+2", 100},
+		{"I18N_A", 2},
+		{"a_1", 5},
+		{"  I18N_A\t+ I18N_B ", 5},
+
+		// precedence and associativity
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"10 - (4 - 3)", 9},
+		{"12 / 3 / 2", 2},
+		{"2 * 3 / 4", 1.5},
+		{"1 - 2 * 3 + 4 / 2", -3},
+		{"((2))", 2},
+		{"(I18N_A - I18N_B) * 1e3", -1000},
+
+		// unary minus
+		{"-2", -2},
+		{"--2", 2},
+		{"-2 * 3", -6},
+		{"-(2 + 3)", -5},
+		{"3 - -2", 5},
+		{"-I18N_A", -2},
+
+		// unknown codes evaluate to 0
+		{"I18N_UNKNOWN", 0},
+		{"I18N_A + I18N_UNKNOWN", 2},
+		{"I18N_A * I18N_UNKNOWN", 0},
+
+		// division by zero evaluates to 0
+		{"1 / 0", 0},
+		{"0 / 0", 0},
+		{"-1 / 0", 0},
+		{"I18N_A / I18N_Z", 0},
+		{"I18N_A / I18N_UNKNOWN", 0},
+		{"1 + I18N_B / (I18N_A - 2)", 1},
+		{"0 / 1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := ParseExpression(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.Eval(vs); got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+			if got := e.String(); got != tt.src {
+				t.Errorf("String() = %q, want %q", got, tt.src)
+			}
+		})
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"1 +",
+		"* 2",
+		"1 2",
+		"I18N_A I18N_B",
+		"(1 + 2",
+		"1 + 2)",
+		"()",
+		"1 ^ 2",
+		"I18N-A + $",
+		"1..2",
+		"1e",
+		"+1",
+	}
+	for _, src := range tests {
+		t.Run(src, func(t *testing.T) {
+			if e, err := ParseExpression(src); err == nil {
+				t.Errorf("ParseExpression() = %v, want an error", e)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package summary
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pmeier/telescope/internal/config"
+)
+
+//go:embed mapping.toml
+var defaultMapping []byte
+
+type mappingFile struct {
+	Services   []string
+	Quantities []config.QuantityConfig
+	Units      map[string]string
+}
+
+type quantityMapping struct {
+	q    Quantity
+	expr *Expression
+}
+
+// Mapping computes the quantities of a summary from the measurements of a source.
+type Mapping struct {
+	services []string
+	qms      []quantityMapping
+	units    map[string]string
+}
+
+// NewMapping registers all quantities that are not built-in and parses their expressions. Derived quantities cannot be
+// mapped, since Derive would overwrite them. The units of the raw values are looked up by I18N code.
+func NewMapping(services []string, qcs []config.QuantityConfig, units map[string]string) (*Mapping, error) {
+	m := &Mapping{services: services, units: units}
+	for _, qc := range qcs {
+		if q, ok := QuantityByName(qc.Name); ok && q.Derived() {
+			return nil, fmt.Errorf("quantity %s is derived from others and cannot be mapped", qc.Name)
+		}
+
+		q, err := RegisterQuantity(qc.Name, qc.Unit)
+		if err != nil {
+			return nil, err
+		}
+
+		expr, err := ParseExpression(qc.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("quantity %s: %w", qc.Name, err)
+		}
+
+		m.qms = append(m.qms, quantityMapping{q: q, expr: expr})
+	}
+	return m, nil
+}
+
+func NewMappingFromConfig(c config.MappingConfig) (*Mapping, error) {
+	data := defaultMapping
+	if c.Path != "" {
+		var err error
+		data, err = os.ReadFile(c.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var mf mappingFile
+	if err := toml.Unmarshal(data, &mf); err != nil {
+		return nil, err
+	}
+
+	services := mf.Services
+	if len(c.Services) > 0 {
+		services = c.Services
+	}
+
+	qcs := mf.Quantities
+	for _, qc := range c.Quantities {
+		i := len(qcs)
+		for j, fqc := range qcs {
+			if fqc.Name == qc.Name {
+				i = j
+				break
+			}
+		}
+		if i == len(qcs) {
+			qcs = append(qcs, qc)
+		} else {
+			qcs[i] = qc
+		}
+	}
+
+	units := map[string]string{}
+	for code, unit := range mf.Units {
+		units[code] = unit
+	}
+	// the config keys are lower case, while the I18N codes are upper case
+	for code, unit := range c.Units {
+		units[strings.ToUpper(code)] = unit
+	}
+
+	return NewMapping(services, qcs, units)
+}
+
+func (m *Mapping) Compute(src Source, deviceID int) (Summary, error) {
+	t := now(src)
+	ms, err := src.RealData(deviceID, m.services...)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	vs := map[string]float64{}
+	raw := RawValues{}
+	for _, msm := range ms {
+		v, err := strconv.ParseFloat(msm.Value, 64)
+		if err != nil {
+			continue
+		}
+		vs[msm.I18NCode] = v
+		unit, ok := m.units[msm.I18NCode]
+		if !ok {
+			unit = msm.Unit
+		}
+		raw[msm.I18NCode] = RawValue{Value: v, Unit: unit}
+	}
+
+	svs := SummaryValues{}
+	for _, qm := range m.qms {
+		svs[qm.q] = float32(qm.expr.Eval(vs))
+	}
+	Derive(svs)
+
+	return Summary{
+		Timestamp: t,
+		Values:    svs,
+		Raw:       raw,
+	}, nil
+}
@@ -0,0 +1,199 @@
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is a single entry of a recording. It holds either the response of Source.Devices or of Source.RealData.
+type Record struct {
+	Timestamp    time.Time     `json:"timestamp"`
+	Devices      []Device      `json:"devices,omitempty"`
+	DeviceID     int           `json:"device_id,omitempty"`
+	Measurements []Measurement `json:"measurements,omitempty"`
+}
+
+// Clock is implemented by sources that are not sampled in real time. Such sources pace the sampling themselves: Wait
+// blocks until the measurements returned by the next RealData call are due and Now reports their timestamp.
+type Clock interface {
+	Now() time.Time
+	Wait(ctx context.Context) error
+}
+
+// RecordingSource writes every response of the wrapped source as JSON encoded Record per line.
+type RecordingSource struct {
+	src Source
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewRecordingSource(src Source, w io.Writer) *RecordingSource {
+	return &RecordingSource{src: src, enc: json.NewEncoder(w)}
+}
+
+func (s *RecordingSource) Devices() ([]Device, error) {
+	t := now(s.src)
+	ds, err := s.src.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	return ds, s.write(Record{Timestamp: t, Devices: ds})
+}
+
+func (s *RecordingSource) RealData(deviceID int, services ...string) ([]Measurement, error) {
+	t := now(s.src)
+	ms, err := s.src.RealData(deviceID, services...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ms, s.write(Record{Timestamp: t, DeviceID: deviceID, Measurements: ms})
+}
+
+func (s *RecordingSource) write(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(r)
+}
+
+// ReplaySource replays a recording written by RecordingSource. The measurements are returned in recorded order
+// regardless of the requested device and services. Once the recording is exhausted, RealData returns io.EOF.
+type ReplaySource struct {
+	f     *os.File
+	dec   *json.Decoder
+	speed float64
+
+	mu      sync.Mutex
+	devices []Device
+	next    *Record
+	origin  time.Time
+	start   time.Time
+}
+
+// NewReplaySource opens the recording at path. The recorded timeline is replayed speed times faster than real time.
+// A speed of zero replays the recording without any delay.
+func NewReplaySource(path string, speed float64) (*ReplaySource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ReplaySource{f: f, dec: json.NewDecoder(f), speed: speed}
+	if err := s.advance(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if s.next == nil {
+		f.Close()
+		return nil, errors.New("recording does not contain any measurements")
+	}
+	s.origin = s.next.Timestamp
+
+	if s.devices == nil {
+		s.devices = []Device{{ID: s.next.DeviceID, Type: summaryDeviceType}}
+	}
+
+	return s, nil
+}
+
+// advance reads the next measurements record and collects all device records on the way.
+func (s *ReplaySource) advance() error {
+	s.next = nil
+	for {
+		var r Record
+		if err := s.dec.Decode(&r); err == io.EOF {
+			return s.close()
+		} else if err != nil {
+			return err
+		}
+
+		if r.Devices != nil && s.devices == nil {
+			s.devices = r.Devices
+		}
+		if r.Measurements != nil {
+			s.next = &r
+			return nil
+		}
+	}
+}
+
+func (s *ReplaySource) Devices() ([]Device, error) {
+	return s.devices, nil
+}
+
+func (s *ReplaySource) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == nil {
+		return time.Now()
+	}
+	return s.next.Timestamp
+}
+
+// Wait blocks until the next measurements are due relative to the first call. It returns early if the context is done.
+func (s *ReplaySource) Wait(ctx context.Context) error {
+	s.mu.Lock()
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	if s.next == nil || s.speed <= 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	due := s.start.Add(time.Duration(float64(s.next.Timestamp.Sub(s.origin)) / s.speed))
+	s.mu.Unlock()
+
+	t := time.NewTimer(time.Until(due))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (s *ReplaySource) RealData(deviceID int, services ...string) ([]Measurement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == nil {
+		return nil, io.EOF
+	}
+
+	ms := s.next.Measurements
+	if err := s.advance(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Close closes the recording. It is closed automatically once it is exhausted.
+func (s *ReplaySource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.close()
+}
+
+func (s *ReplaySource) close() error {
+	if s.f == nil {
+		return nil
+	}
+	f := s.f
+	s.f = nil
+	return f.Close()
+}
+
+func now(src Source) time.Time {
+	if c, ok := src.(Clock); ok {
+		return c.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,45 @@
+package summary
+
+import (
+	"github.com/pmeier/redgiant"
+	"github.com/rs/zerolog"
+
+	rghttp "github.com/pmeier/redgiant/http"
+)
+
+type RedgiantSource struct {
+	rg *rghttp.Redgiant
+}
+
+func NewRedgiantSource(host string, port uint, log zerolog.Logger) *RedgiantSource {
+	return &RedgiantSource{rg: rghttp.NewRedgiant(host, port, redgiant.WithLogger(log))}
+}
+
+func (s *RedgiantSource) Devices() ([]Device, error) {
+	rds, err := s.rg.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	// redgiant only reports the ID and type of the devices, such that they cannot be pinned by serial number
+	ds := make([]Device, 0, len(rds))
+	for _, rd := range rds {
+		ds = append(ds, Device{ID: int(rd.ID), Type: int(rd.Type)})
+	}
+	return ds, nil
+}
+
+// RealData returns the measurements without units, since redgiant does not report them. They are taken from the
+// mapping instead.
+func (s *RedgiantSource) RealData(deviceID int, services ...string) ([]Measurement, error) {
+	rms, err := s.rg.RealData(deviceID, redgiant.NoLanguage, services...)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := make([]Measurement, 0, len(rms))
+	for _, rm := range rms {
+		ms = append(ms, Measurement{I18NCode: rm.I18NCode, Value: rm.Value})
+	}
+	return ms, nil
+}
@@ -0,0 +1,116 @@
+package summary
+
+import (
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pmeier/telescope/internal/config"
+)
+
+const simulatedDeviceID = 1
+
+// SimulatedSource emulates a plant with PV, load and battery. It reports its values under the same I18N codes as a
+// Sungrow inverter, such that the default mapping can be used unchanged.
+type SimulatedSource struct {
+	c config.SimulateConfig
+
+	mu           sync.Mutex
+	rnd          *rand.Rand
+	last         time.Time
+	batteryLevel float64
+	clouds       float64
+}
+
+func NewSimulatedSource(c config.SimulateConfig) *SimulatedSource {
+	return &SimulatedSource{
+		c:            c,
+		rnd:          rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		batteryLevel: c.InitialBatteryLevel,
+		clouds:       1,
+	}
+}
+
+func (s *SimulatedSource) Devices() ([]Device, error) {
+	return []Device{{ID: simulatedDeviceID, Type: summaryDeviceType, Serial: "SIMULATED", Name: "Simulated Plant"}}, nil
+}
+
+func (s *SimulatedSource) RealData(deviceID int, services ...string) ([]Measurement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var dt float64
+	if !s.last.IsZero() {
+		dt = now.Sub(s.last).Hours()
+	}
+	s.last = now
+
+	pv := s.pvPower(now)
+	load := s.loadPower()
+	battery := s.batteryPower(pv-load, dt)
+	grid := load + battery - pv
+
+	if s.c.BatteryCapacity > 0 {
+		s.batteryLevel = min(max(s.batteryLevel+battery*dt/s.c.BatteryCapacity, 0), 1)
+	}
+
+	// the inverter reports powers in kW and the battery level in percent
+	vs := map[string]float64{
+		"I18N_CONFIG_KEY_4060":                        max(grid, 0) * 1e-3,
+		"I18N_COMMON_FEED_NETWORK_TOTAL_ACTIVE_POWER": max(-grid, 0) * 1e-3,
+		"I18N_CONFIG_KEY_3921":                        max(battery, 0) * 1e-3,
+		"I18N_CONFIG_KEY_3907":                        max(-battery, 0) * 1e-3,
+		"I18N_COMMON_TOTAL_DCPOWER":                   pv * 1e-3,
+		"I18N_COMMON_LOAD_TOTAL_ACTIVE_POWER":         load * 1e-3,
+		"I18N_COMMON_BATTERY_SOC":                     s.batteryLevel * 1e2,
+	}
+
+	ms := make([]Measurement, 0, len(vs))
+	for code, v := range vs {
+		unit := "kW"
+		if code == "I18N_COMMON_BATTERY_SOC" {
+			unit = "%"
+		}
+		ms = append(ms, Measurement{I18NCode: code, Value: strconv.FormatFloat(v, 'f', 3, 64), Unit: unit})
+	}
+	return ms, nil
+}
+
+func (s *SimulatedSource) pvPower(t time.Time) float64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	daylight := (t.Sub(midnight) - s.c.Sunrise).Seconds() / (s.c.Sunset - s.c.Sunrise).Seconds()
+	if daylight <= 0 || daylight >= 1 {
+		return 0
+	}
+
+	// clouds drift slowly between overcast and clear sky
+	s.clouds = min(max(s.clouds+s.rnd.NormFloat64()*0.05, 0.3), 1)
+
+	return s.c.PeakPVPower * math.Sin(math.Pi*daylight) * s.clouds
+}
+
+func (s *SimulatedSource) loadPower() float64 {
+	return max(s.c.BaseLoadPower+s.rnd.NormFloat64()*s.c.LoadNoise, 0)
+}
+
+// batteryPower returns the charging (positive) or discharging (negative) power of the battery given the surplus of PV
+// over load power and the duration in hours the power will be applied for.
+func (s *SimulatedSource) batteryPower(surplus float64, dt float64) float64 {
+	p := min(math.Abs(surplus), s.c.MaxBatteryPower)
+
+	var capacity float64
+	if surplus > 0 {
+		capacity = (1 - s.batteryLevel) * s.c.BatteryCapacity
+	} else {
+		capacity = max(s.batteryLevel-s.c.MinBatteryLevel, 0) * s.c.BatteryCapacity
+		p = -p
+	}
+	if dt > 0 {
+		p = math.Copysign(min(math.Abs(p), capacity/dt), p)
+	}
+
+	return p
+}
@@ -0,0 +1,68 @@
+package summary
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pmeier/telescope/internal/config"
+	"github.com/rs/zerolog"
+)
+
+type Device struct {
+	ID     int    `json:"id"`
+	Type   int    `json:"type"`
+	Serial string `json:"serial,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+type Measurement struct {
+	I18NCode string `json:"i18n_code"`
+	Value    string `json:"value"`
+	Unit     string `json:"unit,omitempty"`
+}
+
+// Source provides the device list and raw measurements that a Summary is computed from.
+type Source interface {
+	Devices() ([]Device, error)
+	RealData(deviceID int, services ...string) ([]Measurement, error)
+}
+
+type Status uint8
+
+const (
+	Available Status = iota
+	Unavailable
+)
+
+func (s Status) String() string {
+	switch s {
+	case Available:
+		return "available"
+	case Unavailable:
+		return "unavailable"
+	default:
+		return strconv.Itoa(int(s))
+	}
+}
+
+// CloseSource releases the resources of the source if it holds any, e.g. the file of a ReplaySource.
+func CloseSource(src Source) error {
+	if c, ok := src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func NewSource(sc config.SourceConfig, log zerolog.Logger) (Source, error) {
+	switch sc.Kind {
+	case config.RedgiantSourceKind:
+		return NewRedgiantSource(sc.Redgiant.Host, sc.Redgiant.Port, log), nil
+	case config.SimulateSourceKind:
+		return NewSimulatedSource(sc.Simulate), nil
+	case config.ReplaySourceKind:
+		return NewReplaySource(sc.Replay.Path, sc.Replay.Speed)
+	default:
+		return nil, fmt.Errorf("unknown source kind %s", sc.Kind)
+	}
+}
@@ -1,13 +1,15 @@
 package summary
 
 import (
+	"encoding/json"
 	"errors"
-	"strconv"
+	"fmt"
+	"math"
+	"regexp"
+	"sync"
 	"time"
 
-	rghttp "github.com/pmeier/redgiant/http"
-
-	"github.com/pmeier/redgiant"
+	"github.com/pmeier/telescope/internal/config"
 )
 
 type Quantity uint8
@@ -18,94 +20,241 @@ const (
 	PVPower
 	LoadPower
 	BatteryLevel
+	GridImportPower
+	GridExportPower
+	BatteryChargePower
+	BatteryDischargePower
+	Autarky
+	SelfConsumptionRate
+)
+
+type quantityInfo struct {
+	name string
+	unit string
+}
+
+var (
+	quantitiesMu sync.RWMutex
+	quantities   = []quantityInfo{
+		GridPower:    {"grid_power", "watts"},
+		BatteryPower: {"battery_power", "watts"},
+		PVPower:      {"pv_power", "watts"},
+		LoadPower:    {"load_power", "watts"},
+		BatteryLevel: {"battery_level", "ratio"},
+
+		GridImportPower:       {"grid_import_power", "watts"},
+		GridExportPower:       {"grid_export_power", "watts"},
+		BatteryChargePower:    {"battery_charge_power", "watts"},
+		BatteryDischargePower: {"battery_discharge_power", "watts"},
+		Autarky:               {"autarky", "ratio"},
+		SelfConsumptionRate:   {"self_consumption_rate", "ratio"},
+	}
 )
 
+var identifierRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// RegisterQuantity registers a quantity in addition to the built-in ones. Registering a name again returns the existing
+// quantity as long as the unit matches or is empty.
+func RegisterQuantity(name string, unit string) (Quantity, error) {
+	quantitiesMu.Lock()
+	defer quantitiesMu.Unlock()
+
+	for i, qi := range quantities {
+		if qi.name != name {
+			continue
+		}
+		if unit != "" && unit != qi.unit {
+			return 0, fmt.Errorf("quantity %s is already registered with unit %s", name, qi.unit)
+		}
+		return Quantity(i), nil
+	}
+
+	if !identifierRegexp.MatchString(name) {
+		return 0, fmt.Errorf("invalid quantity name %q", name)
+	}
+	if !identifierRegexp.MatchString(unit) {
+		return 0, fmt.Errorf("invalid unit %q of quantity %s", unit, name)
+	}
+	if len(quantities) > math.MaxUint8 {
+		return 0, errors.New("too many quantities")
+	}
+
+	quantities = append(quantities, quantityInfo{name: name, unit: unit})
+	return Quantity(len(quantities) - 1), nil
+}
+
+func (q Quantity) info() quantityInfo {
+	quantitiesMu.RLock()
+	defer quantitiesMu.RUnlock()
+
+	if int(q) >= len(quantities) {
+		return quantityInfo{}
+	}
+	return quantities[q]
+}
+
 func (q Quantity) Name() string {
-	return map[Quantity]string{
-		GridPower:    "grid_power",
-		BatteryPower: "battery_power",
-		PVPower:      "pv_power",
-		LoadPower:    "load_power",
-		BatteryLevel: "battery_level",
-	}[q]
+	return q.info().name
 }
 
 func (q Quantity) Unit() string {
-	return map[Quantity]string{
-		GridPower:    "watts",
-		BatteryPower: "watts",
-		PVPower:      "watts",
-		LoadPower:    "watts",
-		BatteryLevel: "ratio",
-	}[q]
+	return q.info().unit
 }
 
 func (q Quantity) String() string {
 	return q.Name()
 }
 
+// Quantities returns the built-in quantities followed by the registered ones.
 func Quantities() []Quantity {
-	return []Quantity{
-		GridPower,
-		BatteryPower,
-		PVPower,
-		LoadPower,
-		BatteryLevel,
+	quantitiesMu.RLock()
+	defer quantitiesMu.RUnlock()
+
+	qs := make([]Quantity, len(quantities))
+	for i := range quantities {
+		qs[i] = Quantity(i)
 	}
+	return qs
 }
 
-func GetDeviceID(rg *rghttp.Redgiant) (int, error) {
-	var deviceID int
-
-	ds, err := rg.Devices()
-	if err != nil {
-		return deviceID, err
+func QuantityByName(name string) (Quantity, bool) {
+	for _, q := range Quantities() {
+		if q.Name() == name {
+			return q, true
+		}
 	}
+	return 0, false
+}
+
+// summaryDeviceType is the type of the device that aggregates the values of the plant.
+const summaryDeviceType = 35
 
+// SelectDevice returns the device pinned by the config or, if none is pinned, the summary device.
+func SelectDevice(ds []Device, c config.DeviceConfig) (Device, error) {
 	for _, d := range ds {
-		if d.Type == 35 {
-			deviceID = d.ID
-			break
+		switch {
+		case c.ID != 0:
+			if d.ID == c.ID {
+				return d, nil
+			}
+		case c.Serial != "":
+			if d.Serial == c.Serial {
+				return d, nil
+			}
+		case d.Type == summaryDeviceType:
+			return d, nil
 		}
 	}
-	if deviceID == 0 {
-		return deviceID, errors.New("no summary device available")
+
+	switch {
+	case c.ID != 0:
+		return Device{}, fmt.Errorf("no device with ID %d available", c.ID)
+	case c.Serial != "":
+		return Device{}, fmt.Errorf("no device with serial %s available", c.Serial)
+	default:
+		return Device{}, errors.New("no summary device available, run the devices command and pin one by ID or serial")
+	}
+}
+
+func GetDeviceID(src Source, c config.DeviceConfig) (int, error) {
+	ds, err := src.Devices()
+	if err != nil {
+		return 0, err
 	}
 
-	return deviceID, nil
+	d, err := SelectDevice(ds, c)
+	if err != nil {
+		return 0, err
+	}
+	return d.ID, nil
 }
 
 type SummaryValues map[Quantity]float32
 
+type RawValue struct {
+	Value float64
+	Unit  string
+}
+
+// RawValues maps the I18N codes of all numeric measurements to their values as reported by the source.
+type RawValues map[string]RawValue
+
 type Summary struct {
 	Timestamp time.Time
-	Values    SummaryValues
+	// Device is the label of the source the summary was computed from.
+	Device string
+	Values SummaryValues
+	// Violations holds the names of the plausibility rules the summary violates.
+	Violations []string
+	// Energy holds the energy since the previous summary.
+	Energy EnergyValues
+	Raw    RawValues
 }
 
-func Compute(rg *rghttp.Redgiant, deviceID int) (Summary, error) {
-	t := time.Now()
-	ms, err := rg.RealData(deviceID, redgiant.NoLanguage, "real", "real_battery")
-	if err != nil {
-		return Summary{}, err
+type jsonValue struct {
+	Value float32 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+type jsonSummary struct {
+	Timestamp  time.Time            `json:"timestamp"`
+	Device     string               `json:"device,omitempty"`
+	Values     map[string]jsonValue `json:"values"`
+	Violations []string             `json:"violations,omitempty"`
+}
+
+func (s Summary) MarshalJSON() ([]byte, error) {
+	vs := make(map[string]jsonValue, len(s.Values))
+	for q, v := range s.Values {
+		vs[q.Name()] = jsonValue{Value: v, Unit: q.Unit()}
+	}
+	return json.Marshal(jsonSummary{Timestamp: s.Timestamp, Device: s.Device, Values: vs, Violations: s.Violations})
+}
+
+// Derived reports whether the quantity is set by Derive rather than computed from the measurements.
+func (q Quantity) Derived() bool {
+	switch q {
+	case GridImportPower, GridExportPower, BatteryChargePower, BatteryDischargePower, Autarky, SelfConsumptionRate:
+		return true
+	default:
+		return false
 	}
+}
 
-	vs := map[string]float32{}
-	for _, m := range ms {
-		v, err := strconv.ParseFloat(m.Value, 32)
-		if err != nil {
-			continue
-		}
-		vs[m.I18NCode] = float32(v)
-	}
-
-	return Summary{
-		Timestamp: t,
-		Values: SummaryValues{
-			GridPower:    (vs["I18N_CONFIG_KEY_4060"] - vs["I18N_COMMON_FEED_NETWORK_TOTAL_ACTIVE_POWER"]) * 1e3,
-			BatteryPower: (vs["I18N_CONFIG_KEY_3921"] - vs["I18N_CONFIG_KEY_3907"]) * 1e3,
-			PVPower:      vs["I18N_COMMON_TOTAL_DCPOWER"] * 1e3,
-			LoadPower:    vs["I18N_COMMON_LOAD_TOTAL_ACTIVE_POWER"] * 1e3,
-			BatteryLevel: vs["I18N_COMMON_BATTERY_SOC"] * 1e-2,
-		},
-	}, nil
+// Derive sets the quantities that are derived from others. GridPower is the difference of import and export and
+// BatteryPower the difference of charge and discharge. They are split by sign into directional quantities.
+func Derive(vs SummaryValues) {
+	vs[GridImportPower] = max(vs[GridPower], 0)
+	vs[GridExportPower] = max(-vs[GridPower], 0)
+	vs[BatteryChargePower] = max(vs[BatteryPower], 0)
+	vs[BatteryDischargePower] = max(-vs[BatteryPower], 0)
+
+	vs[Autarky] = float32(autarky(float64(vs[LoadPower]), float64(vs[GridImportPower])))
+	vs[SelfConsumptionRate] = float32(selfConsumption(float64(vs[PVPower]), float64(vs[GridExportPower])))
+}
+
+// EnergyRatios returns the Autarky and SelfConsumptionRate over the period the energy was integrated over.
+func EnergyRatios(ev EnergyValues) SummaryValues {
+	return SummaryValues{
+		Autarky:             float32(autarky(ev[Consumption], ev[GridImport])),
+		SelfConsumptionRate: float32(selfConsumption(ev[PVYield], ev[GridExport])),
+	}
+}
+
+// autarky is the share of the load covered without importing from the grid. Without any load, there is nothing to
+// import and thus the autarky is 1.
+func autarky(load float64, imported float64) float64 {
+	if load <= 0 {
+		return 1
+	}
+	return min(max((load-imported)/load, 0), 1)
+}
+
+// selfConsumption is the share of the PV power used on site. Without any PV power, nothing can be used and thus the
+// self-consumption is 0.
+func selfConsumption(pv float64, exported float64) float64 {
+	if pv <= 0 {
+		return 0
+	}
+	return min(max((pv-exported)/pv, 0), 1)
 }
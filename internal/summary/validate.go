@@ -0,0 +1,105 @@
+package summary
+
+import (
+	"math"
+	"sync"
+
+	"github.com/pmeier/telescope/internal/config"
+)
+
+type Rule struct {
+	Name string
+	// Check returns true if the summary is plausible.
+	Check func(Summary) bool
+}
+
+// AllZeroRule flags summaries without any measured value, e.g. if the source dropped a reading. Derived quantities are
+// ignored, since they need not be zero for zero inputs, e.g. the Autarky.
+func AllZeroRule() Rule {
+	return Rule{
+		Name: "all_zero",
+		Check: func(s Summary) bool {
+			for q, v := range s.Values {
+				if !q.Derived() && v != 0 {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+func BatteryLevelRule() Rule {
+	return Rule{
+		Name: "battery_level",
+		Check: func(s Summary) bool {
+			l := s.Values[BatteryLevel]
+			return l >= 0 && l <= 1
+		},
+	}
+}
+
+// EnergyBalanceRule checks that the power sources, i.e. PV and grid, match the power sinks, i.e. battery and load.
+// The tolerance accounts for conversion losses and measurements not being taken at exactly the same time.
+func EnergyBalanceRule(absoluteTolerance float64, relativeTolerance float64) Rule {
+	return Rule{
+		Name: "energy_balance",
+		Check: func(s Summary) bool {
+			pv := float64(s.Values[PVPower])
+			load := float64(s.Values[LoadPower])
+			residual := pv + float64(s.Values[GridPower]) - float64(s.Values[BatteryPower]) - load
+			return math.Abs(residual) <= absoluteTolerance+relativeTolerance*max(pv, load)
+		},
+	}
+}
+
+type Validator struct {
+	rules  []Rule
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func NewValidator(rules ...Rule) *Validator {
+	return &Validator{rules: rules, counts: map[string]uint64{}}
+}
+
+func NewValidatorFromConfig(c config.ValidationConfig) *Validator {
+	rules := []Rule{}
+	if c.AllZero {
+		rules = append(rules, AllZeroRule())
+	}
+	if c.BatteryLevel {
+		rules = append(rules, BatteryLevelRule())
+	}
+	if c.EnergyBalance.Enabled {
+		rules = append(rules, EnergyBalanceRule(c.EnergyBalance.AbsoluteTolerance, c.EnergyBalance.RelativeTolerance))
+	}
+	return NewValidator(rules...)
+}
+
+// Validate returns the names of the rules the summary violates.
+func (v *Validator) Validate(s Summary) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	violations := []string{}
+	for _, r := range v.rules {
+		if !r.Check(s) {
+			violations = append(violations, r.Name)
+			v.counts[r.Name]++
+		}
+	}
+	return violations
+}
+
+// Counts returns how often each rule was violated.
+func (v *Validator) Counts() map[string]uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	counts := make(map[string]uint64, len(v.counts))
+	for name, c := range v.counts {
+		counts[name] = c
+	}
+	return counts
+}